@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// legacySkipList is the memtable skiplist the sharded storage used before the
+// lock-free one: a node per key holding a slice of next pointers, and a fresh
+// update slice for every Set. It is not safe for concurrent use.
+
+const (
+	legacyMaxLevel    = 32
+	legacyProbability = 0.5
+)
+
+type legacyNode struct {
+	key         string
+	value       []byte
+	flags       uint32
+	isTombstone bool
+	next        []*legacyNode
+}
+
+type legacySkipList struct {
+	head  *legacyNode
+	level int
+	size  int64
+}
+
+func newLegacySkipList() *legacySkipList {
+	return &legacySkipList{
+		head:  &legacyNode{next: make([]*legacyNode, legacyMaxLevel)},
+		level: 1,
+	}
+}
+
+func (s *legacySkipList) randomLevel() int {
+	lvl := 1
+	for rand.Float64() < legacyProbability && lvl < legacyMaxLevel {
+		lvl++
+	}
+
+	return lvl
+}
+
+func (s *legacySkipList) Set(key string, value []byte, flags uint32, isTombstone bool) {
+	update := make([]*legacyNode, legacyMaxLevel)
+	current := s.head
+
+	for i := s.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && current.next[i].key < key {
+			current = current.next[i]
+		}
+		update[i] = current
+	}
+
+	target := current.next[0]
+
+	if target != nil && target.key == key {
+		target.value = value
+		target.flags = flags
+		target.isTombstone = isTombstone
+		return
+	}
+
+	newLevel := s.randomLevel()
+	if newLevel > s.level {
+		for i := s.level; i < newLevel; i++ {
+			update[i] = s.head
+		}
+		s.level = newLevel
+	}
+
+	newNode := &legacyNode{
+		key:         key,
+		value:       value,
+		flags:       flags,
+		isTombstone: isTombstone,
+		next:        make([]*legacyNode, newLevel),
+	}
+
+	for i := 0; i < newLevel; i++ {
+		newNode.next[i] = update[i].next[i]
+		update[i].next[i] = newNode
+	}
+
+	s.size += int64(len(key) + len(value) + 12)
+}
+
+func (s *legacySkipList) Get(key string) ([]byte, uint32, bool, bool) {
+	current := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && current.next[i].key < key {
+			current = current.next[i]
+		}
+	}
+
+	target := current.next[0]
+	if target != nil && target.key == key {
+		return target.value, target.flags, target.isTombstone, true
+	}
+
+	return nil, 0, false, false
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	strg "lsm/internal/storage"
+)
+
+const (
+	ColorReset  = "\033[0m"
+	ColorCyan   = "\033[36m"
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	ColorBlue   = "\033[34m"
+	ColorBold   = "\033[1m"
+)
+
+const (
+	TotalOps    = 1000000
+	KeySpace    = 200000
+	ShardsCount = 16
+	Workers     = 64
+	DataValue   = "bench-value-payload"
+)
+
+// memtable is what both designs offer to the storage.
+type memtable interface {
+	Set(key string, value []byte, seq uint64)
+	Get(key string, seq uint64) bool
+}
+
+// shardedMemtable is the previous design: the old pointer skiplist per shard,
+// written under the shard mutex and read under its read lock. It keeps one
+// version per key, so sequence numbers are ignored.
+type shardedMemtable struct {
+	shards     []*lockedShard
+	shardsSize int64
+}
+
+type lockedShard struct {
+	mu       sync.RWMutex
+	skipList *legacySkipList
+}
+
+func newShardedMemtable() *shardedMemtable {
+	m := &shardedMemtable{shards: make([]*lockedShard, ShardsCount)}
+	for i := range m.shards {
+		m.shards[i] = &lockedShard{skipList: newLegacySkipList()}
+	}
+
+	return m
+}
+
+func (m *shardedMemtable) shard(key string) *lockedShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return m.shards[h.Sum32()%ShardsCount]
+}
+
+func (m *shardedMemtable) Set(key string, value []byte, seq uint64) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	oldSize := sh.skipList.size
+	sh.skipList.Set(key, value, 0, false)
+	newSize := sh.skipList.size
+	sh.mu.Unlock()
+
+	atomic.AddInt64(&m.shardsSize, newSize-oldSize)
+}
+
+func (m *shardedMemtable) Get(key string, seq uint64) bool {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	_, _, _, found := sh.skipList.Get(key)
+	sh.mu.RUnlock()
+
+	return found
+}
+
+// lockFreeMemtable is a single skiplist shared by all goroutines.
+type lockFreeMemtable struct {
+	skipList *strg.SkipList
+	size     int64
+}
+
+func (m *lockFreeMemtable) Set(key string, value []byte, seq uint64) {
+	atomic.AddInt64(&m.size, m.skipList.Set(key, value, 0, false, seq, 0))
+}
+
+func (m *lockFreeMemtable) Get(key string, seq uint64) bool {
+	_, _, _, found := m.skipList.Get(key, seq)
+	return found
+}
+
+func main() {
+	printHeader()
+
+	keys := make([]string, KeySpace)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	for _, writePercent := range []int{100, 50, 10} {
+		fmt.Printf("\n%s%s🔹 %d%% writes, %d ops...%s\n", ColorBold, ColorCyan, writePercent, TotalOps, ColorReset)
+
+		sharded := run(newShardedMemtable(), keys, writePercent)
+		lockFree := run(&lockFreeMemtable{skipList: strg.NewSkipList()}, keys, writePercent)
+
+		printResult("Sharded + mutex", sharded)
+		printResult("Lock-free", lockFree)
+		fmt.Printf("   %s📈 Speedup:%s  %s%.2fx%s\n", ColorBlue, ColorReset, ColorGreen, sharded.Seconds()/lockFree.Seconds(), ColorReset)
+	}
+
+	fmt.Printf("\n%s%s✨ Benchmark Session Completed!%s\n", ColorBold, ColorGreen, ColorReset)
+}
+
+func run(m memtable, keys []string, writePercent int) time.Duration {
+	var wg sync.WaitGroup
+	var opsDone int64
+	var seq uint64
+	value := []byte(DataValue)
+	start := time.Now()
+
+	for i := 0; i < Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			r := rand.New(rand.NewSource(int64(id)))
+			for atomic.AddInt64(&opsDone, 1) <= TotalOps {
+				key := keys[r.Intn(len(keys))]
+
+				if r.Intn(100) < writePercent {
+					m.Set(key, value, atomic.AddUint64(&seq, 1))
+				} else {
+					m.Get(key, atomic.LoadUint64(&seq))
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return time.Since(start)
+}
+
+func printResult(name string, duration time.Duration) {
+	opsPerSec := float64(TotalOps) / duration.Seconds()
+	fmt.Printf("   %s⏱  %-16s%s %v, %s%.0f ops/sec%s\n", ColorBlue, name+":", ColorReset, duration, ColorGreen, opsPerSec, ColorReset)
+}
+
+func printHeader() {
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Printf("%s%s  MEMTABLE CONCURRENCY BENCHMARK%s\n", ColorBold, ColorYellow, ColorReset)
+	fmt.Printf("  Shards: %s%d%s | Workers: %s%d%s | CPUs: %s%d%s\n",
+		ColorCyan, ShardsCount, ColorReset, ColorCyan, Workers, ColorReset, ColorCyan, runtime.GOMAXPROCS(0), ColorReset)
+	fmt.Println(strings.Repeat("=", 60))
+}
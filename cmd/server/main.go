@@ -8,9 +8,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 const Port = 11211
+const Version = "1.0.0"
 const MaxConnections = 1000000
 const ShutdownTimeout = 30
 
@@ -21,16 +23,44 @@ const DataDir = "./../../data/"
 const BlockSize = 1024 * 4
 const MaxMemSize = 1024 * 1024 * 64
 const ShardsCount = 32
+const WALSyncPolicy = strg.WALSyncInterval
+const WALSyncInterval = 10 * time.Millisecond
+const CompactionStrategy = strg.CompactionSizeTiered
 
 func main() {
-	storage, err := strg.NewStorage(DataDir, BlockSize, MaxMemSize, ShardsCount)
+	storage, err := strg.NewStorage(strg.Options{
+		DataDir:         DataDir,
+		BlockSize:       BlockSize,
+		MaxMemSize:      MaxMemSize,
+		ShardsCount:     ShardsCount,
+		WALSyncPolicy:   WALSyncPolicy,
+		WALSyncInterval: WALSyncInterval,
+
+		CompactionStrategy: CompactionStrategy,
+	})
 	if err != nil {
 		panic(err)
 	}
 
+	flushAllHandler := handler.NewFlushAllCommandHandler(storage)
+
 	connectionHandler := srv.NewConnectionHandler()
 	connectionHandler.RegisterHandler(handler.NewGetCommandHandler(storage))
+	connectionHandler.RegisterHandler(handler.NewGatCommandHandler(storage))
+	connectionHandler.RegisterHandler(handler.NewGatsCommandHandler(storage))
 	connectionHandler.RegisterHandler(handler.NewSetCommandHandler(storage, SetBodyMaxAllowedSize, SetMaxConcurrentRequests))
+	connectionHandler.RegisterHandler(handler.NewAddCommandHandler(storage, SetBodyMaxAllowedSize, SetMaxConcurrentRequests))
+	connectionHandler.RegisterHandler(handler.NewReplaceCommandHandler(storage, SetBodyMaxAllowedSize, SetMaxConcurrentRequests))
+	connectionHandler.RegisterHandler(handler.NewAppendCommandHandler(storage, SetBodyMaxAllowedSize, SetMaxConcurrentRequests))
+	connectionHandler.RegisterHandler(handler.NewPrependCommandHandler(storage, SetBodyMaxAllowedSize, SetMaxConcurrentRequests))
+	connectionHandler.RegisterHandler(handler.NewDeleteCommandHandler(storage))
+	connectionHandler.RegisterHandler(handler.NewIncrCommandHandler(storage))
+	connectionHandler.RegisterHandler(handler.NewDecrCommandHandler(storage))
+	connectionHandler.RegisterHandler(handler.NewTouchCommandHandler(storage))
+	connectionHandler.RegisterHandler(flushAllHandler)
+	connectionHandler.RegisterHandler(handler.NewVersionCommandHandler(Version))
+	connectionHandler.RegisterHandler(handler.NewVerbosityCommandHandler())
+	connectionHandler.RegisterHandler(handler.NewQuitCommandHandler())
 
 	server := srv.NewServer(Port, MaxConnections, ShutdownTimeout, connectionHandler)
 
@@ -53,6 +83,8 @@ func main() {
 		log.Println("Error during server stop", err)
 	}
 
+	flushAllHandler.Close()
+
 	log.Println("Closing storage...")
 	err = storage.Close()
 	if err != nil {
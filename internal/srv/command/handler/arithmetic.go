@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"strconv"
+)
+
+const (
+	incrCommandName = "INCR"
+	decrCommandName = "DECR"
+)
+
+// ArithmeticCommandHandler handles "incr|decr <key> <value> [noreply]" on items
+// holding a decimal unsigned 64 bit integer.
+type ArithmeticCommandHandler struct {
+	name  string
+	apply func(key string, delta uint64) (uint64, bool, error)
+}
+
+func NewIncrCommandHandler(storage *strg.Storage) *ArithmeticCommandHandler {
+	return &ArithmeticCommandHandler{
+		name:  incrCommandName,
+		apply: storage.Increment,
+	}
+}
+
+func NewDecrCommandHandler(storage *strg.Storage) *ArithmeticCommandHandler {
+	return &ArithmeticCommandHandler{
+		name:  decrCommandName,
+		apply: storage.Decrement,
+	}
+}
+
+func (h *ArithmeticCommandHandler) Name() string {
+	return h.name
+}
+
+func (h *ArithmeticCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 3 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	err := checkKey(parts[1])
+	if err != nil {
+		return err
+	}
+
+	delta, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return internal_error.NewClientError("invalid numeric delta argument", err)
+	}
+
+	value, found, err := h.apply(parts[1], delta)
+	if errors.Is(err, strg.ErrNotNumeric) {
+		return internal_error.NewClientError("cannot increment or decrement non-numeric value", nil)
+	}
+
+	err = storageError(err)
+	if err != nil {
+		return err
+	}
+
+	if noreply(parts, 3) {
+		return nil
+	}
+
+	if !found {
+		_, err = writer.Write(respNotFound)
+		return err
+	}
+
+	var numBuf [20]byte
+	_, err = writer.Write(strconv.AppendUint(numBuf[:0], value, 10))
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(crlf)
+	return err
+}
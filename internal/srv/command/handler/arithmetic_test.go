@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestArithmeticCommands(t *testing.T) {
+	storage := newTestStorage(t)
+	set := NewSetCommandHandler(storage, 32, 1)
+	incr := NewIncrCommandHandler(storage)
+	decr := NewDecrCommandHandler(storage)
+	get := NewGetCommandHandler(storage)
+
+	runSteps(t, []step{
+		{h: incr, line: "incr n 1", want: "NOT_FOUND\r\n"},
+		{h: decr, line: "decr n 1 noreply", want: ""},
+		{h: set, line: "set n 3 0 2", body: "10\r\n", want: "STORED\r\n"},
+		{h: incr, line: "incr n 5", want: "15\r\n"},
+		{h: decr, line: "decr n 20", want: "0\r\n"},
+		{h: incr, line: "incr n 7 noreply", want: ""},
+		{h: get, line: "get n", want: "VALUE n 3 1\r\n7\r\nEND\r\n"},
+		{h: set, line: "set n 0 0 20", body: "18446744073709551615\r\n", want: "STORED\r\n"},
+		{h: incr, line: "incr n 2", want: "1\r\n"},
+		{h: set, line: "set s 0 0 3", body: "abc\r\n", want: "STORED\r\n"},
+		{h: incr, line: "incr s 1", clientErr: true},
+		{h: incr, line: "incr n x", clientErr: true},
+		{h: incr, line: "incr n -1", clientErr: true},
+		{h: decr, line: "decr n", clientErr: true},
+	})
+}
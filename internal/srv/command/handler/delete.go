@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bufio"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+)
+
+const deleteCommandName = "DELETE"
+
+var (
+	respDeleted  = []byte("DELETED\r\n")
+	respNotFound = []byte("NOT_FOUND\r\n")
+)
+
+type DeleteCommandHandler struct {
+	storage *strg.Storage
+}
+
+func NewDeleteCommandHandler(storage *strg.Storage) *DeleteCommandHandler {
+	return &DeleteCommandHandler{
+		storage: storage,
+	}
+}
+
+func (h *DeleteCommandHandler) Name() string {
+	return deleteCommandName
+}
+
+// Handle answers "delete <key> [0] [noreply]", the legacy zero time is
+// accepted and ignored.
+func (h *DeleteCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 2 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	err := checkKey(parts[1])
+	if err != nil {
+		return err
+	}
+
+	replyIdx := 2
+	if len(parts) > 2 && parts[2] == "0" {
+		replyIdx = 3
+	}
+
+	if len(parts) > replyIdx+1 || (len(parts) > replyIdx && !noreply(parts, replyIdx)) {
+		return internal_error.NewClientError("bad command line format", nil)
+	}
+
+	deleted, err := h.storage.DeleteExisting(parts[1])
+	err = storageError(err)
+	if err != nil {
+		return err
+	}
+
+	if noreply(parts, replyIdx) {
+		return nil
+	}
+
+	if !deleted {
+		_, err = writer.Write(respNotFound)
+		return err
+	}
+
+	_, err = writer.Write(respDeleted)
+	return err
+}
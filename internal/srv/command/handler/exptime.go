@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+)
+
+// maxRelativeExptime is the largest exptime memcached reads as seconds from
+// now, larger values are unix times.
+const maxRelativeExptime = 60 * 60 * 24 * 30
+
+// parseExptime turns a memcached exptime into a unix time in seconds, 0 means
+// the item never expires and a negative exptime expires it right away.
+func parseExptime(s string, now time.Time) (int64, error) {
+	exptime, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case exptime == 0:
+		return 0, nil
+	case exptime < 0:
+		return now.Unix(), nil
+	case exptime <= maxRelativeExptime:
+		return now.Unix() + exptime, nil
+	default:
+		return exptime, nil
+	}
+}
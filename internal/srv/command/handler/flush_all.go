@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"bufio"
+	"log"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const flushAllCommandName = "FLUSH_ALL"
+
+var respOK = []byte("OK\r\n")
+
+// FlushAllCommandHandler keeps the timers of delayed flushes, Close stops them
+// before the storage is closed.
+type FlushAllCommandHandler struct {
+	storage *strg.Storage
+	mu      sync.Mutex
+	timers  map[*time.Timer]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewFlushAllCommandHandler(storage *strg.Storage) *FlushAllCommandHandler {
+	return &FlushAllCommandHandler{
+		storage: storage,
+		timers:  make(map[*time.Timer]struct{}),
+	}
+}
+
+func (h *FlushAllCommandHandler) Name() string {
+	return flushAllCommandName
+}
+
+// Handle answers "flush_all [delay] [noreply]". All items are removed with a
+// single range deletion, after delay seconds when one is given.
+func (h *FlushAllCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	replyIdx := 1
+	var delay int64
+	if len(parts) > 1 && !noreply(parts, 1) {
+		var err error
+		delay, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || delay < 0 {
+			return internal_error.NewClientError("invalid delay", err)
+		}
+		replyIdx = 2
+	}
+
+	if delay > 0 {
+		h.schedule(time.Duration(delay) * time.Second)
+	} else {
+		err := storageError(h.storage.ClearRange("", flushAllEnd))
+		if err != nil {
+			return err
+		}
+	}
+
+	if noreply(parts, replyIdx) {
+		return nil
+	}
+
+	_, err := writer.Write(respOK)
+	return err
+}
+
+func (h *FlushAllCommandHandler) schedule(delay time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	// The timer is registered before its function can take the lock.
+	var timer *time.Timer
+	h.wg.Add(1)
+	timer = time.AfterFunc(delay, func() {
+		defer h.wg.Done()
+
+		h.mu.Lock()
+		delete(h.timers, timer)
+		h.mu.Unlock()
+
+		err := h.storage.ClearRange("", flushAllEnd)
+		if err != nil {
+			log.Printf("Error during delayed flush_all %v", err)
+		}
+	})
+	h.timers[timer] = struct{}{}
+}
+
+// Close cancels the pending delayed flushes and waits for the running ones.
+func (h *FlushAllCommandHandler) Close() {
+	h.mu.Lock()
+	h.closed = true
+	for timer := range h.timers {
+		if timer.Stop() {
+			h.wg.Done()
+		}
+	}
+	h.timers = nil
+	h.mu.Unlock()
+
+	h.wg.Wait()
+}
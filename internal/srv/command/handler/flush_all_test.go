@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushAll(t *testing.T) {
+	storage := newTestStorage(t)
+	h := NewFlushAllCommandHandler(storage)
+	defer h.Close()
+
+	err := storage.Set("k", []byte("v"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	out, err := handle(h, "flush_all", "")
+	if err != nil || out != "OK\r\n" {
+		t.Fatalf("flush_all = %q, %v", out, err)
+	}
+
+	_, _, found, err := storage.Get("k")
+	if err != nil || found {
+		t.Fatalf("Get after flush_all = %v, %v", found, err)
+	}
+
+	out, err = handle(h, "flush_all noreply", "")
+	if err != nil || out != "" {
+		t.Fatalf("flush_all noreply = %q, %v", out, err)
+	}
+
+	_, err = handle(h, "flush_all -1", "")
+	if err == nil {
+		t.Fatal("flush_all -1 succeeded")
+	}
+}
+
+func TestFlushAllCloseStopsDelayedFlush(t *testing.T) {
+	storage := newTestStorage(t)
+	h := NewFlushAllCommandHandler(storage)
+
+	err := storage.Set("k", []byte("v"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	out, err := handle(h, "flush_all 1", "")
+	if err != nil || out != "OK\r\n" {
+		t.Fatalf("flush_all 1 = %q, %v", out, err)
+	}
+
+	h.Close()
+
+	if len(h.timers) != 0 {
+		t.Fatalf("%d timers left after Close", len(h.timers))
+	}
+
+	// A flush scheduled after Close is dropped as well.
+	_, err = handle(h, "flush_all 1", "")
+	if err != nil || len(h.timers) != 0 {
+		t.Fatalf("flush_all after Close = %v, %d timers", err, len(h.timers))
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	_, _, found, err := storage.Get("k")
+	if err != nil || !found {
+		t.Fatalf("Get after Close = %v, %v", found, err)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"bufio"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"time"
+)
+
+const (
+	gatCommandName  = "GAT"
+	gatsCommandName = "GATS"
+)
+
+// GatCommandHandler handles "gat|gats <exptime> <key>*", a get that also sets
+// a new exptime on the items found. gats answers like gets.
+type GatCommandHandler struct {
+	storage *strg.Storage
+	name    string
+	withCas bool
+}
+
+func NewGatCommandHandler(storage *strg.Storage) *GatCommandHandler {
+	return &GatCommandHandler{
+		storage: storage,
+		name:    gatCommandName,
+	}
+}
+
+func NewGatsCommandHandler(storage *strg.Storage) *GatCommandHandler {
+	return &GatCommandHandler{
+		storage: storage,
+		name:    gatsCommandName,
+		withCas: true,
+	}
+}
+
+func (h *GatCommandHandler) Name() string {
+	return h.name
+}
+
+func (h *GatCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 3 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	expiresAt, err := parseExptime(parts[1], time.Now())
+	if err != nil {
+		return internal_error.NewClientError("invalid exptime", err)
+	}
+
+	for _, key := range parts[2:] {
+		err = checkKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range parts[2:] {
+		data, flags, found, err := h.storage.GetAndTouch(key, expiresAt)
+		err = storageError(err)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			continue
+		}
+
+		err = writeValue(writer, key, flags, data, h.withCas)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = writer.Write(respEnd)
+	return err
+}
@@ -11,8 +11,7 @@ const getCommandName = "GET"
 
 var (
 	respValue = []byte("VALUE ")
-	respEnd   = []byte("\r\nEND\r\n")
-	respEmpty = []byte("(empty)\r\n")
+	respEnd   = []byte("END\r\n")
 	space     = []byte(" ")
 	crlf      = []byte("\r\n")
 )
@@ -31,6 +30,7 @@ func (h *GetCommandHandler) Name() string {
 	return getCommandName
 }
 
+// Handle answers "get <key>*" with a value for every key found and END.
 func (h *GetCommandHandler) Handle(
 	reader *bufio.Reader,
 	writer *bufio.Writer,
@@ -42,26 +42,46 @@ func (h *GetCommandHandler) Handle(
 		return internal_error.NewClientError("missing arguments", nil)
 	}
 
-	data, flags, found, err := h.storage.Get(parts[1])
-	if err != nil {
-		return err
+	for _, key := range parts[1:] {
+		err := checkKey(key)
+		if err != nil {
+			return err
+		}
 	}
 
-	if !found {
-		_, err = writer.Write(respEmpty)
-		return err
+	for _, key := range parts[1:] {
+		data, flags, found, err := h.storage.Get(key)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			continue
+		}
+
+		err = writeValue(writer, key, flags, data, false)
+		if err != nil {
+			return err
+		}
 	}
 
+	_, err := writer.Write(respEnd)
+	return err
+}
+
+// writeValue writes "VALUE <key> <flags> <bytes>[ <cas unique>]\r\n<data>\r\n".
+// There are no cas uniques, withCas writes 0.
+func writeValue(writer *bufio.Writer, key string, flags uint32, data []byte, withCas bool) error {
 	var numBuf [20]byte
 
-	// "VALUE "
-	_, err = writer.Write(respValue)
+	// 1. "VALUE "
+	_, err := writer.Write(respValue)
 	if err != nil {
 		return err
 	}
 
 	// 2. "<key> "
-	_, err = writer.WriteString(parts[1])
+	_, err = writer.WriteString(key)
 	if err != nil {
 		return err
 	}
@@ -81,11 +101,19 @@ func (h *GetCommandHandler) Handle(
 		return err
 	}
 
-	// 4. "<bytes>\r\n"
+	// 4. "<bytes>[ <cas unique>]\r\n"
 	_, err = writer.Write(strconv.AppendUint(numBuf[:0], uint64(len(data)), 10))
 	if err != nil {
 		return err
 	}
+
+	if withCas {
+		_, err = writer.WriteString(" 0")
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = writer.Write(crlf)
 	if err != nil {
 		return err
@@ -97,8 +125,8 @@ func (h *GetCommandHandler) Handle(
 		return err
 	}
 
-	// 6. "\r\nEND\r\n"
-	_, err = writer.Write(respEnd)
+	// 6. "\r\n"
+	_, err = writer.Write(crlf)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *strg.Storage {
+	t.Helper()
+
+	storage, err := strg.NewStorage(strg.Options{
+		DataDir:            t.TempDir(),
+		BlockSize:          4096,
+		MaxMemSize:         1 << 20,
+		ShardsCount:        4,
+		CompactionInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	t.Cleanup(func() {
+		storage.Close()
+	})
+
+	return storage
+}
+
+// handle runs the command line against the handler with the rest of the
+// request in body and returns what it wrote.
+func handle(h Handler, line string, body string) (string, error) {
+	reader := bufio.NewReader(strings.NewReader(body))
+	var out bytes.Buffer
+	writer := bufio.NewWriter(&out)
+
+	err := h.Handle(reader, writer, strings.Fields(line))
+	writer.Flush()
+
+	return out.String(), err
+}
+
+// step is a command sent to a handler with the reply it expects, or a client
+// error when clientErr is set.
+type step struct {
+	h         Handler
+	line      string
+	body      string
+	want      string
+	clientErr bool
+}
+
+func runSteps(t *testing.T, steps []step) {
+	t.Helper()
+
+	for _, s := range steps {
+		out, err := handle(s.h, s.line, s.body)
+
+		var clientErr *internal_error.ClientError
+		if s.clientErr {
+			if !errors.As(err, &clientErr) {
+				t.Fatalf("%q returned %q, %v, want a client error", s.line, out, err)
+			}
+			continue
+		}
+
+		if err != nil || out != s.want {
+			t.Fatalf("%q returned %q, %v, want %q", s.line, out, err, s.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"lsm/internal/srv/internal_error"
+	"strings"
+)
+
+// maxKeyLength is the memcached limit on key length. Every key sorts before
+// flushAllEnd, so a single range deletion up to it removes all of them.
+const maxKeyLength = 250
+
+var flushAllEnd = strings.Repeat("\xff", maxKeyLength+1)
+
+func checkKey(key string) error {
+	if len(key) > maxKeyLength {
+		return internal_error.NewClientError("key is too long", nil)
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return internal_error.NewClientError("key contains control characters", nil)
+		}
+	}
+
+	return nil
+}
+
+// noreply tells whether the optional argument at idx asks to send no reply.
+func noreply(parts []string, idx int) bool {
+	return len(parts) > idx && parts[idx] == "noreply"
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+)
+
+const quitCommandName = "QUIT"
+
+// ErrQuit asks the connection handler to close the connection.
+var ErrQuit = errors.New("client quit")
+
+type QuitCommandHandler struct{}
+
+func NewQuitCommandHandler() *QuitCommandHandler {
+	return &QuitCommandHandler{}
+}
+
+func (h *QuitCommandHandler) Name() string {
+	return quitCommandName
+}
+
+func (h *QuitCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	return ErrQuit
+}
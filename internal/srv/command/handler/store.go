@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	setCommandName     = "SET"
+	addCommandName     = "ADD"
+	replaceCommandName = "REPLACE"
+	appendCommandName  = "APPEND"
+	prependCommandName = "PREPEND"
+)
+
+var (
+	respStored    = []byte("STORED\r\n")
+	respNotStored = []byte("NOT_STORED\r\n")
+)
+
+// storeFunc stores the value of a storage command and tells whether it was
+// stored.
+type storeFunc func(key string, value []byte, flags uint32, expiresAt int64) (bool, error)
+
+// StoreCommandHandler handles the storage commands, they share the command line
+// "<command> <key> <flags> <exptime> <bytes> [noreply]" and the data block.
+type StoreCommandHandler struct {
+	name                  string
+	store                 storeFunc
+	bodyBufferPool        sync.Pool
+	semaphore             chan struct{}
+	bodyMaxAllowedSize    int
+	maxConcurrentRequests int
+}
+
+func NewSetCommandHandler(
+	storage *strg.Storage,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	store := func(key string, value []byte, flags uint32, expiresAt int64) (bool, error) {
+		return true, storage.SetWithExpiry(key, value, flags, expiresAt)
+	}
+
+	return newStoreCommandHandler(setCommandName, store, bodyMaxAllowedSize, maxConcurrentRequests)
+}
+
+func NewAddCommandHandler(
+	storage *strg.Storage,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	return newStoreCommandHandler(addCommandName, storage.Add, bodyMaxAllowedSize, maxConcurrentRequests)
+}
+
+func NewReplaceCommandHandler(
+	storage *strg.Storage,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	return newStoreCommandHandler(replaceCommandName, storage.Replace, bodyMaxAllowedSize, maxConcurrentRequests)
+}
+
+// NewAppendCommandHandler ignores the flags and the exptime of the command,
+// the item keeps its own.
+func NewAppendCommandHandler(
+	storage *strg.Storage,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	store := func(key string, value []byte, flags uint32, expiresAt int64) (bool, error) {
+		return storage.Append(key, value)
+	}
+
+	return newStoreCommandHandler(appendCommandName, store, bodyMaxAllowedSize, maxConcurrentRequests)
+}
+
+func NewPrependCommandHandler(
+	storage *strg.Storage,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	store := func(key string, value []byte, flags uint32, expiresAt int64) (bool, error) {
+		return storage.Prepend(key, value)
+	}
+
+	return newStoreCommandHandler(prependCommandName, store, bodyMaxAllowedSize, maxConcurrentRequests)
+}
+
+func newStoreCommandHandler(
+	name string,
+	store storeFunc,
+	bodyMaxAllowedSize int,
+	maxConcurrentRequests int,
+) *StoreCommandHandler {
+	return &StoreCommandHandler{
+		name:  name,
+		store: store,
+		bodyBufferPool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, bodyMaxAllowedSize)
+			},
+		},
+		semaphore:             make(chan struct{}, maxConcurrentRequests),
+		bodyMaxAllowedSize:    bodyMaxAllowedSize,
+		maxConcurrentRequests: maxConcurrentRequests,
+	}
+}
+
+func (h *StoreCommandHandler) Name() string {
+	return h.name
+}
+
+func (h *StoreCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 5 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	bytesLen, err := strconv.Atoi(parts[4])
+	if err != nil || bytesLen < 0 {
+		return internal_error.NewClientError("invalid length", nil)
+	}
+
+	flags, expiresAt, err := h.parseHeader(parts, bytesLen)
+	if err != nil {
+		// The data block is swallowed like memcached does, so it is not read
+		// as the next command.
+		_, discardErr := reader.Discard(bytesLen + 2)
+		if discardErr != nil {
+			return discardErr
+		}
+		return err
+	}
+
+	h.semaphore <- struct{}{}
+	fullBuf := h.bodyBufferPool.Get().([]byte)
+	defer h.bodyBufferPool.Put(fullBuf)
+	defer func() { <-h.semaphore }()
+
+	data := fullBuf[:bytesLen]
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = reader.Discard(2)
+	if err != nil {
+		return err
+	}
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+
+	stored, err := h.store(parts[1], dataCopy, flags, expiresAt)
+	err = storageError(err)
+	if err != nil {
+		return err
+	}
+
+	if noreply(parts, 5) {
+		return nil
+	}
+
+	if !stored {
+		_, err = writer.Write(respNotStored)
+		return err
+	}
+
+	_, err = writer.Write(respStored)
+	return err
+}
+
+func (h *StoreCommandHandler) parseHeader(parts []string, bytesLen int) (uint32, int64, error) {
+	err := checkKey(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	flags, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return 0, 0, internal_error.NewClientError("invalid flags", err)
+	}
+
+	expiresAt, err := parseExptime(parts[3], time.Now())
+	if err != nil {
+		return 0, 0, internal_error.NewClientError("invalid exptime", err)
+	}
+
+	if bytesLen > h.bodyMaxAllowedSize {
+		return 0, 0, internal_error.NewClientError("value is too large (max 5MB)", nil)
+	}
+
+	return uint32(flags), expiresAt, nil
+}
+
+// storageError turns the storage errors a client can act on into client,
+// retryable and server errors.
+func storageError(err error) error {
+	if errors.Is(err, strg.ErrKeyTooLarge) || errors.Is(err, strg.ErrValueTooLarge) {
+		return internal_error.NewClientError(err.Error(), err)
+	}
+
+	if errors.Is(err, strg.ErrWriteStall) {
+		return internal_error.NewRetryableError("writes are stalled, retry later", err)
+	}
+
+	if errors.Is(err, strg.ErrFlushFailed) {
+		return internal_error.NewServerError("writes are disabled after a failed flush", err)
+	}
+
+	return err
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestStoreDiscardsDataAfterClientError(t *testing.T) {
+	storage := newTestStorage(t)
+	h := NewSetCommandHandler(storage, 8, 1)
+
+	for _, line := range []string{
+		"set k x 0 5",
+		"set k 0 x 5",
+		"set k\x01 0 0 5",
+		"set k 0 0 10",
+	} {
+		body := "hello\r\n"
+		if strings.HasSuffix(line, "10") {
+			body = "helloworld\r\n"
+		}
+
+		reader := bufio.NewReader(strings.NewReader(body + "get k\r\n"))
+		writer := bufio.NewWriter(&bytes.Buffer{})
+
+		err := h.Handle(reader, writer, strings.Fields(line))
+		var clientErr *internal_error.ClientError
+		if !errors.As(err, &clientErr) {
+			t.Fatalf("%q returned %v, want a client error", line, err)
+		}
+
+		rest, _ := io.ReadAll(reader)
+		if string(rest) != "get k\r\n" {
+			t.Fatalf("%q left %q to read, want the next command", line, rest)
+		}
+	}
+}
+
+func TestStoreCommands(t *testing.T) {
+	storage := newTestStorage(t)
+	set := NewSetCommandHandler(storage, 16, 1)
+	add := NewAddCommandHandler(storage, 16, 1)
+	replace := NewReplaceCommandHandler(storage, 16, 1)
+	appendH := NewAppendCommandHandler(storage, 16, 1)
+	prepend := NewPrependCommandHandler(storage, 16, 1)
+	get := NewGetCommandHandler(storage)
+
+	runSteps(t, []step{
+		{h: replace, line: "replace k 0 0 1", body: "r\r\n", want: "NOT_STORED\r\n"},
+		{h: appendH, line: "append k 0 0 1", body: "a\r\n", want: "NOT_STORED\r\n"},
+		{h: add, line: "add k 5 0 1", body: "1\r\n", want: "STORED\r\n"},
+		{h: add, line: "add k 0 0 1", body: "2\r\n", want: "NOT_STORED\r\n"},
+		{h: get, line: "get k", want: "VALUE k 5 1\r\n1\r\nEND\r\n"},
+		{h: replace, line: "replace k 6 0 1", body: "3\r\n", want: "STORED\r\n"},
+		{h: appendH, line: "append k 9 0 2", body: "45\r\n", want: "STORED\r\n"},
+		{h: prepend, line: "prepend k 9 0 2 noreply", body: "12\r\n", want: ""},
+		{h: get, line: "get k", want: "VALUE k 6 5\r\n12345\r\nEND\r\n"},
+		{h: set, line: "set k 0 0 2 noreply", body: "ok\r\n", want: ""},
+		{h: add, line: "add n 0 0 1 noreply", body: "1\r\n", want: ""},
+		{h: get, line: "get k n missing", want: "VALUE k 0 2\r\nok\r\nVALUE n 0 1\r\n1\r\nEND\r\n"},
+		{h: set, line: "set k 0 0", clientErr: true},
+		{h: set, line: "set k 0 0 -1", clientErr: true},
+		{h: set, line: "set k 0 0 17", body: strings.Repeat("x", 17) + "\r\n", clientErr: true},
+		{h: get, line: "get", clientErr: true},
+		{h: get, line: "get " + strings.Repeat("k", 251), clientErr: true},
+	})
+}
+
+func TestStorageErrorReplies(t *testing.T) {
+	var clientErr *internal_error.ClientError
+	if !errors.As(storageError(strg.ErrKeyTooLarge), &clientErr) {
+		t.Fatal("a too large key is not a client error")
+	}
+
+	var retryableErr *internal_error.RetryableError
+	if !errors.As(storageError(fmt.Errorf("%w: timeout", strg.ErrWriteStall)), &retryableErr) {
+		t.Fatal("a write stall is not retryable")
+	}
+
+	var serverErr *internal_error.ServerError
+	if !errors.As(storageError(fmt.Errorf("%w: disk full", strg.ErrFlushFailed)), &serverErr) {
+		t.Fatal("a failed flush is not a server error")
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"bufio"
+	"lsm/internal/srv/internal_error"
+	strg "lsm/internal/storage"
+	"time"
+)
+
+const touchCommandName = "TOUCH"
+
+var respTouched = []byte("TOUCHED\r\n")
+
+type TouchCommandHandler struct {
+	storage *strg.Storage
+}
+
+func NewTouchCommandHandler(storage *strg.Storage) *TouchCommandHandler {
+	return &TouchCommandHandler{
+		storage: storage,
+	}
+}
+
+func (h *TouchCommandHandler) Name() string {
+	return touchCommandName
+}
+
+// Handle answers "touch <key> <exptime> [noreply]".
+func (h *TouchCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 3 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	err := checkKey(parts[1])
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := parseExptime(parts[2], time.Now())
+	if err != nil {
+		return internal_error.NewClientError("invalid exptime", err)
+	}
+
+	touched, err := h.storage.Touch(parts[1], expiresAt)
+	err = storageError(err)
+	if err != nil {
+		return err
+	}
+
+	if noreply(parts, 3) {
+		return nil
+	}
+
+	if !touched {
+		_, err = writer.Write(respNotFound)
+		return err
+	}
+
+	_, err = writer.Write(respTouched)
+	return err
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestTouchGatAndDeleteCommands(t *testing.T) {
+	storage := newTestStorage(t)
+	set := NewSetCommandHandler(storage, 32, 1)
+	get := NewGetCommandHandler(storage)
+	gat := NewGatCommandHandler(storage)
+	gats := NewGatsCommandHandler(storage)
+	touch := NewTouchCommandHandler(storage)
+	del := NewDeleteCommandHandler(storage)
+
+	runSteps(t, []step{
+		{h: touch, line: "touch k 0", want: "NOT_FOUND\r\n"},
+		{h: set, line: "set k 1 0 1", body: "v\r\n", want: "STORED\r\n"},
+		{h: set, line: "set j 2 0 1", body: "w\r\n", want: "STORED\r\n"},
+		{h: touch, line: "touch k 100", want: "TOUCHED\r\n"},
+		{h: gat, line: "gat 100 k missing j", want: "VALUE k 1 1\r\nv\r\nVALUE j 2 1\r\nw\r\nEND\r\n"},
+		{h: gats, line: "gats 0 k", want: "VALUE k 1 1 0\r\nv\r\nEND\r\n"},
+		{h: touch, line: "touch k -1 noreply", want: ""},
+		{h: get, line: "get k j", want: "VALUE j 2 1\r\nw\r\nEND\r\n"},
+		{h: del, line: "delete j", want: "DELETED\r\n"},
+		{h: del, line: "delete j", want: "NOT_FOUND\r\n"},
+		{h: del, line: "delete k noreply", want: ""},
+		{h: get, line: "get j", want: "END\r\n"},
+		{h: touch, line: "touch k", clientErr: true},
+		{h: touch, line: "touch k x", clientErr: true},
+		{h: gat, line: "gat 0", clientErr: true},
+		{h: del, line: "delete", clientErr: true},
+	})
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"bufio"
+	"lsm/internal/srv/internal_error"
+	"strconv"
+)
+
+const verbosityCommandName = "VERBOSITY"
+
+// VerbosityCommandHandler accepts "verbosity <level> [noreply]" for client
+// compatibility, the server logs the same at every level.
+type VerbosityCommandHandler struct{}
+
+func NewVerbosityCommandHandler() *VerbosityCommandHandler {
+	return &VerbosityCommandHandler{}
+}
+
+func (h *VerbosityCommandHandler) Name() string {
+	return verbosityCommandName
+}
+
+func (h *VerbosityCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	if len(parts) < 2 {
+		return internal_error.NewClientError("missing arguments", nil)
+	}
+
+	_, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return internal_error.NewClientError("invalid level", err)
+	}
+
+	if noreply(parts, 2) {
+		return nil
+	}
+
+	_, err = writer.Write(respOK)
+	return err
+}
@@ -0,0 +1,30 @@
+package handler
+
+import "bufio"
+
+const versionCommandName = "VERSION"
+
+type VersionCommandHandler struct {
+	response []byte
+}
+
+func NewVersionCommandHandler(version string) *VersionCommandHandler {
+	return &VersionCommandHandler{
+		response: []byte("VERSION " + version + "\r\n"),
+	}
+}
+
+func (h *VersionCommandHandler) Name() string {
+	return versionCommandName
+}
+
+func (h *VersionCommandHandler) Handle(
+	reader *bufio.Reader,
+	writer *bufio.Writer,
+	parts []string,
+) error {
+	defer writer.Flush()
+
+	_, err := writer.Write(h.response)
+	return err
+}
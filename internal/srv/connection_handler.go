@@ -14,6 +14,8 @@ import (
 
 const ReadTimeout = 30
 
+var respError = []byte("ERROR\r\n")
+
 type ConnectionHandler struct {
 	commandHandlers map[string]handler.Handler
 }
@@ -47,9 +49,10 @@ func (h *ConnectionHandler) handle(conn net.Conn) error {
 			return err
 		}
 
+		// Unknown and empty commands get the plain memcached ERROR reply.
 		parts := strings.Fields(line)
 		if len(parts) == 0 {
-			_, err = conn.Write([]byte(fmt.Sprintf("(empty command)\r\n", err)))
+			_, err = conn.Write(respError)
 			if err != nil {
 				return err
 			}
@@ -60,7 +63,7 @@ func (h *ConnectionHandler) handle(conn net.Conn) error {
 		cmd := strings.ToUpper(parts[0])
 		hndlr, ok := h.commandHandlers[cmd]
 		if !ok {
-			_, err = conn.Write([]byte(fmt.Sprintf("(invalid command)\r\n", err)))
+			_, err = conn.Write(respError)
 			if err != nil {
 				return err
 			}
@@ -70,9 +73,33 @@ func (h *ConnectionHandler) handle(conn net.Conn) error {
 
 		err = hndlr.Handle(reader, writer, parts)
 		if err != nil {
+			if errors.Is(err, handler.ErrQuit) {
+				return nil
+			}
+
 			var clientErr *internal_error.ClientError
 			if errors.As(err, &clientErr) {
-				_, err = conn.Write([]byte(fmt.Sprintf("(%s)\r\n", err)))
+				_, err = conn.Write([]byte(fmt.Sprintf("CLIENT_ERROR %s\r\n", err)))
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			var retryableErr *internal_error.RetryableError
+			if errors.As(err, &retryableErr) {
+				_, err = conn.Write([]byte(fmt.Sprintf("SERVER_ERROR %s\r\n", retryableErr.Message)))
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			var serverErr *internal_error.ServerError
+			if errors.As(err, &serverErr) {
+				_, err = conn.Write([]byte(fmt.Sprintf("SERVER_ERROR %s\r\n", serverErr.Message)))
 				if err != nil {
 					return err
 				}
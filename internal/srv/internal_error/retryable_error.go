@@ -0,0 +1,16 @@
+package internal_error
+
+// RetryableError is a temporary server side failure, the client can send the
+// same command again later.
+type RetryableError struct {
+	BaseError
+}
+
+func NewRetryableError(msg string, err error) *RetryableError {
+	return &RetryableError{
+		BaseError{
+			Message: msg,
+			Err:     err,
+		},
+	}
+}
@@ -0,0 +1,16 @@
+package internal_error
+
+// ServerError is a server side failure that retrying does not fix, the
+// connection stays usable for other commands.
+type ServerError struct {
+	BaseError
+}
+
+func NewServerError(msg string, err error) *ServerError {
+	return &ServerError{
+		BaseError{
+			Message: msg,
+			Err:     err,
+		},
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// arena hands out runs of T from large chunks and addresses them by reference:
+// the chunk number, counted from one, in the high 32 bits and the offset in
+// the low ones, so 0 is never a valid reference. Chunks of pointer free types
+// are not scanned by the garbage collector and a memtable is released as a
+// few allocations. Runs are claimed with an atomic add, only adding a chunk
+// takes the lock.
+type arena[T any] struct {
+	mu        sync.Mutex
+	chunkSize int
+	current   atomic.Pointer[arenaChunk[T]]
+	chunks    atomic.Pointer[[][]T]
+	allocated int64
+}
+
+type arenaChunk[T any] struct {
+	ref  uint64
+	used int64
+	data []T
+}
+
+func (a *arena[T]) alloc(n int) (uint64, []T) {
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Runs larger than a quarter chunk get a chunk of their own, so they do
+	// not waste the rest of the current one.
+	if n > a.chunkSize/4 {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		c := a.addChunk(n)
+		return c.ref, c.data
+	}
+
+	for {
+		c := a.current.Load()
+		if c != nil {
+			end := atomic.AddInt64(&c.used, int64(n))
+			if end <= int64(len(c.data)) {
+				start := end - int64(n)
+				return c.ref + uint64(start), c.data[start:end:end]
+			}
+		}
+
+		a.mu.Lock()
+		if a.current.Load() == c {
+			a.current.Store(a.addChunk(a.chunkSize))
+		}
+		a.mu.Unlock()
+	}
+}
+
+// addChunk publishes a new chunk, the chunk list is copied so readers never
+// see it change.
+func (a *arena[T]) addChunk(size int) *arenaChunk[T] {
+	var chunks [][]T
+	if p := a.chunks.Load(); p != nil {
+		chunks = *p
+	}
+
+	data := make([]T, size)
+	chunks = append(chunks[:len(chunks):len(chunks)], data)
+	a.chunks.Store(&chunks)
+	atomic.AddInt64(&a.allocated, int64(size))
+
+	return &arenaChunk[T]{ref: uint64(len(chunks)) << 32, data: data}
+}
+
+// capacity returns the number of elements of all chunks, used or not.
+func (a *arena[T]) capacity() int64 {
+	return atomic.LoadInt64(&a.allocated)
+}
+
+// get returns everything from the referenced run to the end of its chunk.
+func (a *arena[T]) get(ref uint64) []T {
+	if ref == 0 {
+		return nil
+	}
+
+	return (*a.chunks.Load())[ref>>32-1][ref&0xffffffff:]
+}
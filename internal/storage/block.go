@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// blockRestartInterval is the number of entries between restart points. Keys
+// at a restart point are stored in full, every other key only stores the part
+// it does not share with the previous key.
+const blockRestartInterval = 16
+
+// dataBlock is a decompressed data block. Since the prefix format the entries
+// are followed by the offsets of the restart points and their count:
+//
+//	entries  restart offsets (u32 each)  restart count (u32)
+type dataBlock struct {
+	data     []byte
+	restarts []byte
+	prefixed bool
+}
+
+func (t *SSTable) parseBlock(buf []byte, offset int64) (*dataBlock, error) {
+	if t.formatVersion < prefixTableFormatVersion {
+		return &dataBlock{data: buf}, nil
+	}
+
+	if len(buf) < 4 {
+		return nil, t.corruption(offset, "block is shorter than its restart count")
+	}
+
+	count := int64(binary.BigEndian.Uint32(buf[len(buf)-4:]))
+	restartsOffset := int64(len(buf)) - 4 - count*4
+	if count == 0 || restartsOffset < 0 {
+		return nil, t.corruption(offset, "restart array is out of bounds")
+	}
+
+	b := &dataBlock{
+		data:     buf[:restartsOffset],
+		restarts: buf[restartsOffset : len(buf)-4],
+		prefixed: true,
+	}
+
+	for i := 0; i < b.numRestarts(); i++ {
+		if int64(b.restart(i)) >= restartsOffset {
+			return nil, t.corruption(offset, "restart point %d is out of bounds", i)
+		}
+	}
+
+	return b, nil
+}
+
+func (b *dataBlock) numRestarts() int {
+	return len(b.restarts) / 4
+}
+
+func (b *dataBlock) restart(i int) int64 {
+	return int64(binary.BigEndian.Uint32(b.restarts[i*4:]))
+}
+
+func (b *dataBlock) size() int64 {
+	return int64(len(b.data) + len(b.restarts))
+}
+
+// decodeBlock decodes all entries of the block, keys are copied out of the
+// block when they are prefix compressed.
+func (t *SSTable) decodeBlock(b *dataBlock, offset int64) ([]decodedEntry, error) {
+	var entries []decodedEntry
+	var keys []byte
+	if b.prefixed {
+		keys = make([]byte, 0, len(b.data))
+	}
+
+	var prev []byte
+	var pos int64 = 0
+	for pos < int64(len(b.data)) {
+		var e decodedEntry
+		next, shared, err := t.decodeEntry(b.data, pos, &e)
+		if err != nil {
+			return nil, t.corruption(offset, "entry at %d: %v", pos, err)
+		}
+
+		if b.prefixed {
+			if shared > len(prev) {
+				return nil, t.corruption(offset, "entry at %d: %v", pos, errEntryOutOfBounds)
+			}
+
+			start := len(keys)
+			keys = append(keys, prev[:shared]...)
+			keys = append(keys, e.key...)
+			e.key = keys[start:len(keys):len(keys)]
+		}
+
+		entries = append(entries, e)
+		prev = e.key
+		pos = next
+	}
+
+	return entries, nil
+}
+
+// seek finds the newest version of key visible at seq. The restart points are
+// binary searched, so only the entries after the closest restart are decoded.
+// The key of the returned entry is only valid until the next call.
+func (t *SSTable) seek(b *dataBlock, offset int64, key []byte, seq uint64) (decodedEntry, bool, error) {
+	var pos int64 = 0
+
+	if b.prefixed {
+		var err error
+		i := sort.Search(b.numRestarts(), func(i int) bool {
+			var e decodedEntry
+			_, shared, decodeErr := t.decodeEntry(b.data, b.restart(i), &e)
+			if decodeErr == nil && shared != 0 {
+				decodeErr = errRestartShared
+			}
+
+			if decodeErr != nil {
+				err = decodeErr
+				return true
+			}
+
+			return bytes.Compare(e.key, key) >= 0
+		})
+		if err != nil {
+			return decodedEntry{}, false, t.corruption(offset, "restart point: %v", err)
+		}
+
+		// The previous restart key is smaller than the searched one, so no
+		// version of it is skipped.
+		if i > 0 {
+			pos = b.restart(i - 1)
+		}
+	}
+
+	var current []byte
+	for pos < int64(len(b.data)) {
+		var e decodedEntry
+		next, shared, err := t.decodeEntry(b.data, pos, &e)
+		if err != nil {
+			return decodedEntry{}, false, t.corruption(offset, "entry at %d: %v", pos, err)
+		}
+
+		if b.prefixed {
+			if shared > len(current) {
+				return decodedEntry{}, false, t.corruption(offset, "entry at %d: %v", pos, errEntryOutOfBounds)
+			}
+
+			current = append(current[:shared], e.key...)
+			e.key = current
+		}
+
+		cmp := bytes.Compare(e.key, key)
+		if cmp > 0 {
+			break
+		}
+
+		if cmp == 0 && e.seq <= seq {
+			return e, true, nil
+		}
+
+		pos = next
+	}
+
+	return decodedEntry{}, false, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultBlockCacheSize = 8 * 1024 * 1024
+	blockCacheShards      = 16
+)
+
+var nextTableCacheID uint64
+
+type BlockCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Inserts   int64
+	Evictions int64
+	Size      int64
+	Capacity  int64
+}
+
+// BlockCache is a sharded LRU of decompressed data blocks shared by all tables,
+// bounded by the memory the blocks take.
+type BlockCache struct {
+	shards    [blockCacheShards]blockCacheShard
+	hits      int64
+	misses    int64
+	inserts   int64
+	evictions int64
+	capacity  int64
+}
+
+type blockCacheKey struct {
+	tableID uint64
+	offset  int64
+}
+
+type blockCacheEntry struct {
+	key   blockCacheKey
+	block *dataBlock
+}
+
+type blockCacheShard struct {
+	mu       sync.Mutex
+	items    map[blockCacheKey]*list.Element
+	lru      list.List
+	size     int64
+	capacity int64
+}
+
+func NewBlockCache(capacity int64) *BlockCache {
+	c := &BlockCache{capacity: capacity}
+	for i := range c.shards {
+		c.shards[i].items = make(map[blockCacheKey]*list.Element)
+		c.shards[i].capacity = capacity / blockCacheShards
+	}
+
+	return c
+}
+
+func newStorageBlockCache(size int64) *BlockCache {
+	if size < 0 {
+		return nil
+	}
+
+	if size == 0 {
+		size = DefaultBlockCacheSize
+	}
+
+	return NewBlockCache(size)
+}
+
+func (c *BlockCache) shard(key blockCacheKey) *blockCacheShard {
+	h := key.tableID*0x9e3779b97f4a7c15 ^ uint64(key.offset)
+	h ^= h >> 29
+
+	return &c.shards[h%blockCacheShards]
+}
+
+func (c *BlockCache) get(key blockCacheKey) (*dataBlock, bool) {
+	sh := c.shard(key)
+
+	sh.mu.Lock()
+	elem, ok := sh.items[key]
+	if ok {
+		sh.lru.MoveToFront(elem)
+	}
+	sh.mu.Unlock()
+
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+
+	return elem.Value.(*blockCacheEntry).block, true
+}
+
+func (c *BlockCache) insert(key blockCacheKey, block *dataBlock) {
+	charge := block.size()
+	sh := c.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if charge > sh.capacity {
+		return
+	}
+
+	if _, ok := sh.items[key]; ok {
+		return
+	}
+
+	sh.items[key] = sh.lru.PushFront(&blockCacheEntry{key: key, block: block})
+	sh.size += charge
+	atomic.AddInt64(&c.inserts, 1)
+
+	for sh.size > sh.capacity {
+		last := sh.lru.Back()
+		entry := last.Value.(*blockCacheEntry)
+
+		sh.lru.Remove(last)
+		delete(sh.items, entry.key)
+		sh.size -= entry.block.size()
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+func (c *BlockCache) Stats() BlockCacheStats {
+	stats := BlockCacheStats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Inserts:   atomic.LoadInt64(&c.inserts),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		Capacity:  c.capacity,
+	}
+
+	for i := range c.shards {
+		c.shards[i].mu.Lock()
+		stats.Size += c.shards[i].size
+		c.shards[i].mu.Unlock()
+	}
+
+	return stats
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestGetSharesCachedBlock(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	err := s.Set("k", []byte("value"), 7)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	err = s.flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	first, flags, found, err := s.Get("k")
+	if err != nil || !found || string(first) != "value" || flags != 7 {
+		t.Fatalf("Get = %q, %d, %v, %v", first, flags, found, err)
+	}
+
+	second, _, _, err := s.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if &first[0] != &second[0] {
+		t.Fatal("a cache hit copied the value")
+	}
+
+	if cap(second) != len(second) {
+		t.Fatalf("value capacity %d reaches past the value", cap(second))
+	}
+
+	stats := s.BlockCacheStats()
+	if stats.Hits == 0 || stats.Misses == 0 {
+		t.Fatalf("got %+v, want a miss and a hit", stats)
+	}
+}
@@ -13,7 +13,13 @@ func NewBloomFilter(n int, p float64) BloomFilter {
 }
 
 func (bf BloomFilter) Add(key []byte) {
-	h := hashKey(key)
+	bf.addHash(hashKey(key))
+}
+
+func (bf BloomFilter) addHash(h [2]uint32) {
+	if len(bf) == 0 {
+		return
+	}
 	m := uint32(len(bf) * 8)
 	for i := 0; i < 4; i++ {
 		idx := (h[0] + uint32(i)*h[1]) % m
@@ -0,0 +1,732 @@
+package storage
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type CompactionStrategy int
+
+const (
+	CompactionSizeTiered CompactionStrategy = iota
+	CompactionLeveled
+)
+
+const DefaultCompactionInterval = 10 * time.Second
+
+var errCompactionInterrupted = errors.New("compaction is interrupted")
+
+type CompactionStats struct {
+	Running           bool
+	Compactions       int64
+	TablesCompacted   int64
+	TablesCreated     int64
+	BytesRead         int64
+	BytesWritten      int64
+	EntriesWritten    int64
+	EntriesDropped    int64
+	TombstonesDropped int64
+	// RangeTombstonesDropped counts the range tombstones that no reader needs
+	// anymore, TablesDropped the tables they deleted as a whole.
+	RangeTombstonesDropped int64
+	TablesDropped          int64
+	LastDuration           time.Duration
+	LiveTables             int
+	LiveTablesSize         int64
+	Levels                 []LevelStats
+	Filters                []CompactionFilterStats
+}
+
+type LevelStats struct {
+	Tables int
+	Size   int64
+}
+
+type compaction struct {
+	level       int
+	outputLevel int
+	// inputs are ordered from the oldest to the newest table.
+	inputs   []*SSTable
+	overlaps []*SSTable
+	// bottommost is set when no older table can hold any of the compacted keys,
+	// so tombstones have nothing left to shadow.
+	bottommost bool
+	// outside are the live tables left out of the compaction that overlap its
+	// keys and may hold versions interleaved with the compacted ones.
+	outside []*SSTable
+	maxSize int64
+	// rangeTombstones are the range tombstones of the live tables that shadow
+	// compacted versions.
+	rangeTombstones []rangeTombstone
+}
+
+type compactionPicker interface {
+	pick(levels [][]*SSTable) *compaction
+	// pendingBytes estimates how many bytes compactions still have to rewrite
+	// to bring the levels back into shape.
+	pendingBytes(levels [][]*SSTable) int64
+}
+
+type compactor struct {
+	storage    *Storage
+	picker     compactionPicker
+	limiter    *rateLimiter
+	trigger    chan struct{}
+	stop       chan struct{}
+	wg         sync.WaitGroup
+	statsMutex sync.Mutex
+	stats      CompactionStats
+	interval   time.Duration
+	filters    []CompactionFilter
+}
+
+func newCompactor(s *Storage, opts Options) *compactor {
+	c := &compactor{
+		storage:  s,
+		limiter:  &rateLimiter{bytesPerSec: opts.CompactionRateLimit},
+		trigger:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		interval: opts.CompactionInterval,
+		filters:  opts.CompactionFilters,
+	}
+	c.stats.Filters = c.newFilterStats()
+
+	if c.interval <= 0 {
+		c.interval = DefaultCompactionInterval
+	}
+
+	switch opts.CompactionStrategy {
+	case CompactionLeveled:
+		c.picker = newLeveledPicker(opts)
+	default:
+		c.picker = newSizeTieredPicker(opts)
+	}
+
+	return c
+}
+
+func (c *compactor) start() {
+	c.wg.Go(c.loop)
+}
+
+func (c *compactor) close() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *compactor) schedule() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (c *compactor) loop() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		case <-c.trigger:
+		}
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+
+			compacted, err := c.compactOnce()
+			if errors.Is(err, errCompactionInterrupted) {
+				return
+			}
+
+			if err != nil {
+				log.Printf("Error during compaction %v", err)
+				break
+			}
+
+			if !compacted {
+				break
+			}
+		}
+	}
+}
+
+// compactOnce retires range tombstones first: tables they delete as a whole
+// are dropped and the tables holding them are compacted alone, before the
+// picker gets a turn.
+func (c *compactor) compactOnce() (bool, error) {
+	snapshots := c.storage.liveSnapshots()
+
+	c.storage.tablesMutex.RLock()
+	edit, obsolete := c.storage.obsoleteTables(snapshots)
+	var comp *compaction
+	if len(obsolete) == 0 {
+		comp = c.storage.rangeTombstoneCompaction(snapshots)
+	}
+	if len(obsolete) == 0 && comp == nil {
+		comp = c.picker.pick(c.storage.levels)
+	}
+	c.storage.tablesMutex.RUnlock()
+
+	if len(obsolete) > 0 {
+		return true, c.dropTables(edit, obsolete)
+	}
+
+	if comp == nil {
+		return false, nil
+	}
+
+	return true, c.compact(comp)
+}
+
+func (c *compactor) dropTables(edit *versionEdit, tables []*SSTable) error {
+	log.Printf("Starting drop of %d tables deleted by range tombstones...", len(tables))
+
+	err := c.storage.logAndApply(edit, nil)
+	if err != nil {
+		return err
+	}
+	c.storage.updateWriteStall()
+
+	for _, t := range tables {
+		err = t.unref()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.statsMutex.Lock()
+	c.stats.TablesDropped += int64(len(tables))
+	c.statsMutex.Unlock()
+
+	log.Println("Tables drop is end")
+
+	return nil
+}
+
+func (c *compactor) compact(comp *compaction) error {
+	startedAt := time.Now()
+
+	c.statsMutex.Lock()
+	c.stats.Running = true
+	c.statsMutex.Unlock()
+
+	defer func() {
+		c.statsMutex.Lock()
+		c.stats.Running = false
+		c.statsMutex.Unlock()
+	}()
+
+	tables := make([]*SSTable, 0, len(comp.overlaps)+len(comp.inputs))
+	tables = append(tables, comp.overlaps...)
+	tables = append(tables, comp.inputs...)
+
+	var bytesRead int64
+	for _, t := range tables {
+		bytesRead += t.size
+	}
+
+	log.Printf("Starting compaction of %d tables (%d bytes) from L%d to L%d...",
+		len(tables), bytesRead, comp.level, comp.outputLevel)
+
+	snapshots := c.storage.liveSnapshots()
+	var keptRangeTombstones []rangeTombstone
+	var rangeTombstonesDropped int64
+	comp.rangeTombstones, keptRangeTombstones, rangeTombstonesDropped = c.storage.compactionRangeTombstones(tables, snapshots)
+
+	output := &compactionOutput{
+		storage:         c.storage,
+		limiter:         c.limiter,
+		maxSize:         comp.maxSize,
+		rangeTombstones: keptRangeTombstones,
+	}
+
+	filterStats := c.newFilterStats()
+	written, dropped, tombstonesDropped, err := c.merge(tables, output, comp, snapshots, filterStats)
+	if err == nil {
+		err = output.finish()
+	}
+
+	if err != nil {
+		output.abort()
+		return err
+	}
+
+	err = c.storage.applyCompaction(comp, output.tables)
+	if err != nil {
+		output.abort()
+		return err
+	}
+
+	var bytesWritten int64
+	for _, t := range output.tables {
+		bytesWritten += t.size
+	}
+
+	for _, t := range tables {
+		err = t.unref()
+		if err != nil {
+			return err
+		}
+	}
+
+	duration := time.Since(startedAt)
+
+	c.statsMutex.Lock()
+	c.stats.Compactions++
+	c.stats.TablesCompacted += int64(len(tables))
+	c.stats.TablesCreated += int64(len(output.tables))
+	c.stats.BytesRead += bytesRead
+	c.stats.BytesWritten += bytesWritten
+	c.stats.EntriesWritten += written
+	c.stats.EntriesDropped += dropped
+	c.stats.TombstonesDropped += tombstonesDropped
+	c.stats.RangeTombstonesDropped += rangeTombstonesDropped
+	c.stats.LastDuration = duration
+	for i := range filterStats {
+		c.stats.Filters[i].Removed += filterStats[i].Removed
+		c.stats.Filters[i].Changed += filterStats[i].Changed
+	}
+	c.statsMutex.Unlock()
+
+	log.Printf("Compaction is end in %v: %d entries written to %d tables, %d dropped",
+		duration, written, len(output.tables), dropped+tombstonesDropped)
+
+	return nil
+}
+
+// merge streams the versions of every key from the tables into compactKey.
+func (c *compactor) merge(tables []*SSTable, output *compactionOutput, comp *compaction, snapshots []uint64, filterStats []CompactionFilterStats) (int64, int64, int64, error) {
+	var written, dropped, tombstonesDropped int64
+
+	h := make(mergeHeap, 0, len(tables))
+	for i, t := range tables {
+		it := t.newIterator()
+		if it.Next() {
+			h = append(h, &mergeSource{it: it, rank: i})
+			continue
+		}
+
+		if it.Err() != nil {
+			return 0, 0, 0, it.Err()
+		}
+	}
+	heap.Init(&h)
+
+	var versions []decodedEntry
+	flushKey := func() error {
+		keyWritten, keyTombstonesDropped, err := c.compactKey(versions, output, comp, snapshots, filterStats)
+		if err != nil {
+			return err
+		}
+
+		written += keyWritten
+		tombstonesDropped += keyTombstonesDropped
+		dropped += int64(len(versions)) - keyWritten - keyTombstonesDropped
+		versions = versions[:0]
+
+		return nil
+	}
+
+	for len(h) > 0 {
+		select {
+		case <-c.stop:
+			return 0, 0, 0, errCompactionInterrupted
+		default:
+		}
+
+		it := h[0].it
+		if len(versions) > 0 && !bytes.Equal(it.entry.key, versions[0].key) {
+			err := flushKey()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		versions = append(versions, it.entry)
+
+		if it.Next() {
+			heap.Fix(&h, 0)
+			continue
+		}
+
+		if it.Err() != nil {
+			return 0, 0, 0, it.Err()
+		}
+
+		heap.Pop(&h)
+	}
+
+	if len(versions) > 0 {
+		err := flushKey()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return written, dropped, tombstonesDropped, nil
+}
+
+// compactKey writes the versions of a key, newest first, that readers can still
+// tell apart: the newest version of every snapshot stripe. A stripe ending in
+// merge operands is folded into a put when the value below the operands is
+// known, otherwise its operands are kept for reads to fold. The newest version
+// goes through the compaction filters when no live snapshot reads it, a removed
+// one is written as a tombstone. Covering range tombstones take part as
+// deletions but are never written for the key.
+func (c *compactor) compactKey(versions []decodedEntry, output *compactionOutput, comp *compaction, snapshots []uint64, filterStats []CompactionFilterStats) (int64, int64, error) {
+	var written, tombstonesDropped int64
+	key := string(versions[0].key)
+	versions = withRangeTombstones(versions, comp.rangeTombstones)
+
+	// Stripes are resolved from the oldest, the value one leaves is the base
+	// of the next. Nothing is below the versions of a bottommost compaction,
+	// an expiring value is not a base since the operands outlive it.
+	var groups [][]decodedEntry
+	var stripes []int
+	known := comp.bottommost
+	var base []byte
+	var baseFlags uint32
+	var baseSeq uint64
+
+	for end := len(versions); end > 0; {
+		stripe := snapshotStripe(snapshots, versions[end-1].seq)
+		start := end - 1
+		for start > 0 && snapshotStripe(snapshots, versions[start-1].seq) == stripe {
+			start--
+		}
+		g := versions[start:end]
+		end = start
+
+		p := 0
+		for p < len(g) && g[p].kind == entryKindMerge {
+			p++
+		}
+
+		if p == 0 {
+			groups = append(groups, g[:1])
+			stripes = append(stripes, stripe)
+			known, base, baseFlags = resolvedBase(g[0])
+			baseSeq = g[0].seq
+			continue
+		}
+
+		baseKnown, existing, flags, existingSeq := known, base, baseFlags, baseSeq
+		if p < len(g) {
+			baseKnown, existing, flags = resolvedBase(g[p])
+			existingSeq = g[p].seq
+		}
+
+		// A version outside the compaction between the base and the operands
+		// would be skipped by the fold, so they are kept for reads instead.
+		if baseKnown && !comp.bottommost && comp.interleaved(key, existingSeq, g[0].seq) {
+			baseKnown = false
+		}
+
+		var value []byte
+		var err error
+		if baseKnown {
+			operands := make([][]byte, p)
+			for i := range operands {
+				operands[i] = g[i].value
+			}
+			value, err = fullMerge(c.storage.mergeOperator, key, existing, operands)
+		}
+
+		if !baseKnown || err != nil {
+			groups = append(groups, g[:min(p+1, len(g))])
+			stripes = append(stripes, stripe)
+			known = false
+			continue
+		}
+
+		e := g[0]
+		e.kind = entryKindPut
+		e.value = value
+		e.flags = flags
+		e.expiresAt = 0
+
+		groups = append(groups, []decodedEntry{e})
+		stripes = append(stripes, stripe)
+		known, base, baseFlags, baseSeq = true, value, flags, e.seq
+	}
+
+	for i := len(groups) - 1; i >= 0; i-- {
+		stripe := stripes[i]
+
+		for j, e := range groups[i] {
+			newest := i == len(groups)-1 && j == 0
+			if newest && e.kind == entryKindPut && len(c.filters) > 0 && stripe >= len(snapshots)-1 {
+				var removed bool
+				e.value, removed = c.filterEntry(comp.outputLevel, key, e.value, e.flags, filterStats)
+				if removed {
+					e.value, e.flags, e.kind, e.expiresAt = nil, 0, entryKindDelete, 0
+				}
+			}
+
+			if e.kind == entryKindRangeDelete {
+				continue
+			}
+
+			if e.kind == entryKindDelete && comp.bottommost && stripe == 0 {
+				tombstonesDropped++
+				continue
+			}
+
+			err := output.add(key, e.value, e.flags, e.kind, e.seq, e.expiresAt)
+			if err != nil {
+				return 0, 0, err
+			}
+			written++
+		}
+	}
+
+	return written, tombstonesDropped, nil
+}
+
+// interleaved reports whether a table outside the compaction may hold a version
+// of the key with a sequence number between from and to.
+func (comp *compaction) interleaved(key string, from uint64, to uint64) bool {
+	for _, t := range comp.outside {
+		if key >= t.minKey && key <= t.maxKey && t.largestSeq > from && t.smallestSeq < to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvedBase returns whether merge operands above the version can be folded
+// into it, and the value and flags they start from.
+func resolvedBase(e decodedEntry) (bool, []byte, uint32) {
+	if e.kind == entryKindDelete || e.kind == entryKindRangeDelete {
+		return true, nil, 0
+	}
+
+	return e.expiresAt == 0, e.value, e.flags
+}
+
+func (c *compactor) statsSnapshot() CompactionStats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+
+	stats := c.stats
+	stats.Filters = append([]CompactionFilterStats(nil), c.stats.Filters...)
+
+	return stats
+}
+
+// compactionOutput splits the compacted entries into tables. The kept range
+// tombstones are cut at the table boundaries, every table gets the part of
+// them between its first key and the first key of the next one.
+type compactionOutput struct {
+	storage         *Storage
+	limiter         *rateLimiter
+	maxSize         int64
+	current         *SSTable
+	paths           []string
+	tables          []*SSTable
+	rangeTombstones []rangeTombstone
+	lower           string
+}
+
+func (o *compactionOutput) add(key string, value []byte, flags uint32, kind byte, seq uint64, expiresAt int64) error {
+	if o.current != nil && o.maxSize > 0 && o.current.offset >= o.maxSize && key != o.current.maxKey {
+		err := o.finishCurrent(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.current == nil {
+		err := o.newTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	return o.current.add(key, value, flags, kind, seq, expiresAt)
+}
+
+func (o *compactionOutput) newTable() error {
+	fileNum := o.storage.newFileNumber()
+	path := tableFileName(o.storage.dataDir, fileNum)
+
+	table, err := newSSTable(path, o.storage.tableOptions, o.limiter)
+	if err != nil {
+		return err
+	}
+
+	table.fileNum = fileNum
+	o.current = table
+	o.paths = append(o.paths, path)
+
+	return nil
+}
+
+// finishCurrent writes the current table, upper is the first key of the next
+// one or empty for the last table.
+func (o *compactionOutput) finishCurrent(upper string) error {
+	table := o.current
+	o.current = nil
+
+	for _, rt := range o.rangeTombstones {
+		rt.start = max(rt.start, o.lower)
+		if upper != "" {
+			rt.end = min(rt.end, upper)
+		}
+
+		if rt.start < rt.end {
+			table.addRangeTombstone(rt)
+		}
+	}
+	o.lower = upper
+
+	err := table.Finish()
+	if err != nil {
+		closeErr := table.Close()
+		if closeErr != nil {
+			return err
+		}
+
+		return err
+	}
+
+	err = table.Close()
+	if err != nil {
+		return err
+	}
+
+	opened, err := table.reopen()
+	if err != nil {
+		return err
+	}
+
+	o.tables = append(o.tables, opened)
+
+	return nil
+}
+
+func (o *compactionOutput) finish() error {
+	// Range tombstones are kept even when every entry was dropped.
+	if o.current == nil {
+		if len(o.rangeTombstones) == 0 {
+			return nil
+		}
+
+		err := o.newTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	return o.finishCurrent("")
+}
+
+func (o *compactionOutput) abort() {
+	if o.current != nil {
+		o.current.Close()
+		o.current = nil
+	}
+
+	for _, t := range o.tables {
+		t.Close()
+	}
+
+	for _, path := range o.paths {
+		os.Remove(path)
+	}
+}
+
+type mergeSource struct {
+	it   *sstableIterator
+	rank int
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int {
+	return len(h)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := &h[i].it.entry, &h[j].it.entry
+
+	if res := bytes.Compare(a.key, b.key); res != 0 {
+		return res < 0
+	}
+
+	if a.seq != b.seq {
+		return a.seq > b.seq
+	}
+
+	return h[i].rank > h[j].rank
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	*h = append(*h, x.(*mergeSource))
+}
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+
+	return x
+}
+
+func overlappingTables(tables []*SSTable, minKey string, maxKey string) []*SSTable {
+	var res []*SSTable
+	for _, t := range tables {
+		if t.maxKey >= minKey && t.minKey <= maxKey {
+			res = append(res, t)
+		}
+	}
+
+	return res
+}
+
+func keyRange(tables ...[]*SSTable) (string, string) {
+	var minKey, maxKey string
+	first := true
+
+	for _, level := range tables {
+		for _, t := range level {
+			if first || t.minKey < minKey {
+				minKey = t.minKey
+			}
+
+			if first || t.maxKey > maxKey {
+				maxKey = t.maxKey
+			}
+
+			first = false
+		}
+	}
+
+	return minKey, maxKey
+}
+
+func levelSize(tables []*SSTable) int64 {
+	var size int64
+	for _, t := range tables {
+		size += t.size
+	}
+
+	return size
+}
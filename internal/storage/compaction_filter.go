@@ -0,0 +1,52 @@
+package storage
+
+type CompactionFilterDecision int
+
+const (
+	CompactionFilterKeep CompactionFilterDecision = iota
+	CompactionFilterRemove
+	CompactionFilterChangeValue
+)
+
+// CompactionFilter runs custom logic on the values compaction rewrites. It sees
+// the newest version of a key that no live snapshot reads, so snapshots keep
+// their view. Removing a value deletes the key, changing it replaces the value
+// with the returned one. The value passed in must not be modified or retained.
+type CompactionFilter interface {
+	Name() string
+	Filter(level int, key string, value []byte, flags uint32) (CompactionFilterDecision, []byte)
+}
+
+type CompactionFilterStats struct {
+	Name    string
+	Removed int64
+	Changed int64
+}
+
+// filterEntry runs the filters in their order, each one sees the value left by
+// the previous. It returns the value to write and whether the key is removed.
+func (c *compactor) filterEntry(level int, key string, value []byte, flags uint32, stats []CompactionFilterStats) ([]byte, bool) {
+	for i, f := range c.filters {
+		decision, newValue := f.Filter(level, key, value, flags)
+
+		switch decision {
+		case CompactionFilterRemove:
+			stats[i].Removed++
+			return nil, true
+		case CompactionFilterChangeValue:
+			stats[i].Changed++
+			value = newValue
+		}
+	}
+
+	return value, false
+}
+
+func (c *compactor) newFilterStats() []CompactionFilterStats {
+	stats := make([]CompactionFilterStats, len(c.filters))
+	for i, f := range c.filters {
+		stats[i].Name = f.Name()
+	}
+
+	return stats
+}
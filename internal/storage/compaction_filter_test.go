@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// prefixFilter decides by the key prefix and records the first value it was
+// shown for every key. A later compaction sees a changed value again, which is
+// kept as is.
+type prefixFilter struct {
+	seen map[string]string
+}
+
+func (f *prefixFilter) Name() string {
+	return "prefix"
+}
+
+func (f *prefixFilter) Filter(level int, key string, value []byte, flags uint32) (CompactionFilterDecision, []byte) {
+	if _, ok := f.seen[key]; !ok {
+		f.seen[key] = string(value)
+	}
+
+	switch {
+	case strings.HasPrefix(key, "remove"):
+		return CompactionFilterRemove, nil
+	case strings.HasPrefix(key, "change") && strings.ToLower(string(value)) == string(value):
+		return CompactionFilterChangeValue, []byte(strings.ToUpper(string(value)))
+	}
+
+	return CompactionFilterKeep, nil
+}
+
+func TestCompactionFilterDecisions(t *testing.T) {
+	filter := &prefixFilter{seen: make(map[string]string)}
+	s := newTestStorage(t, Options{
+		CompactionFilters:      []CompactionFilter{filter},
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	// An old version below the compaction the removal has to shadow.
+	addTestTable(t, s, 2, decodedEntry{key: []byte("remove"), value: []byte("old"), kind: entryKindPut, seq: nextTestSeq(s)})
+
+	write := func(key string, value string) {
+		t.Helper()
+
+		err := s.Set(key, []byte(value), 0)
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+	}
+
+	write("keep", "keep")
+	write("change", "change")
+	write("remove", "remove")
+
+	compactTestStorage(t, s)
+
+	want := map[string]string{"keep": "keep", "change": "change", "remove": "remove"}
+	for key, value := range want {
+		if filter.seen[key] != value {
+			t.Fatalf("filter saw %q=%q, want %q", key, filter.seen[key], value)
+		}
+	}
+
+	for key, want := range map[string]string{"keep": "keep", "change": "CHANGE"} {
+		if value, found := mustGet(t, s, key); !found || value != want {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, value, found, want)
+		}
+	}
+
+	if value, found := mustGet(t, s, "remove"); found {
+		t.Fatalf("Get(remove) = %q, want no value", value)
+	}
+
+	// The removed value is written as a tombstone over the older version.
+	e, found, err := s.getFromTables("remove", s.commits.visible())
+	if err != nil || !found || e.kind != entryKindDelete {
+		t.Fatalf("tables hold remove as kind %d, %v, %v, want a tombstone", e.kind, found, err)
+	}
+
+	stats := s.CompactionStats().Filters
+	if len(stats) != 1 || stats[0].Name != "prefix" || stats[0].Removed != 1 || stats[0].Changed != 1 {
+		t.Fatalf("filter stats %+v, want one removed and one changed by prefix", stats)
+	}
+}
+
+func TestCompactionFilterSkipsSnapshotVersions(t *testing.T) {
+	filter := &prefixFilter{seen: make(map[string]string)}
+	s := newTestStorage(t, Options{
+		CompactionFilters:      []CompactionFilter{filter},
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	write := func(key string, value string) {
+		t.Helper()
+
+		err := s.Set(key, []byte(value), 0)
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+	}
+
+	// A single table exists before the snapshot, so nothing is compacted
+	// without it.
+	err := s.Set("change-read", []byte("old"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	write("change-rewritten", "old")
+	snap := s.NewSnapshot()
+	write("change-rewritten", "new")
+
+	compactTestStorage(t, s)
+
+	// The snapshot reads the newest version of change-read, the filter must
+	// not touch it, while the version written after the snapshot is fair game.
+	if value, ok := filter.seen["change-read"]; ok {
+		t.Fatalf("filter saw change-read=%q visible to a snapshot", value)
+	}
+	if filter.seen["change-rewritten"] != "new" {
+		t.Fatalf("filter saw change-rewritten=%q, want %q", filter.seen["change-rewritten"], "new")
+	}
+
+	for key, want := range map[string]string{"change-read": "old", "change-rewritten": "old"} {
+		value, _, found, err := snap.Get(key)
+		if err != nil || !found || string(value) != want {
+			t.Fatalf("snapshot Get(%q) = %q, %v, %v, want %q", key, value, found, err, want)
+		}
+	}
+
+	for key, want := range map[string]string{"change-read": "old", "change-rewritten": "NEW"} {
+		if value, found := mustGet(t, s, key); !found || value != want {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, value, found, want)
+		}
+	}
+
+	snap.Release()
+}
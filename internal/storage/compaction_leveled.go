@@ -0,0 +1,148 @@
+package storage
+
+import "sync"
+
+const (
+	DefaultMaxLevels           = 7
+	DefaultL0CompactionTrigger = 4
+	DefaultLevelBaseSize       = 10 * 1024 * 1024
+	DefaultLevelSizeMultiplier = 10
+	DefaultTargetFileSize      = 2 * 1024 * 1024
+)
+
+type leveledPicker struct {
+	mu                  sync.Mutex
+	maxLevels           int
+	l0CompactionTrigger int
+	levelBaseSize       int64
+	levelSizeMultiplier int64
+	targetFileSize      int64
+	// compactPointers remember where the previous compaction of every level
+	// stopped, so tables of a level are compacted round-robin.
+	compactPointers []string
+}
+
+func newLeveledPicker(opts Options) *leveledPicker {
+	p := &leveledPicker{
+		maxLevels:           opts.MaxLevels,
+		l0CompactionTrigger: opts.L0CompactionTrigger,
+		levelBaseSize:       opts.LevelBaseSize,
+		levelSizeMultiplier: int64(opts.LevelSizeMultiplier),
+		targetFileSize:      opts.TargetFileSize,
+	}
+
+	if p.maxLevels < 2 {
+		p.maxLevels = DefaultMaxLevels
+	}
+
+	if p.l0CompactionTrigger <= 0 {
+		p.l0CompactionTrigger = DefaultL0CompactionTrigger
+	}
+
+	if p.levelBaseSize <= 0 {
+		p.levelBaseSize = DefaultLevelBaseSize
+	}
+
+	if p.levelSizeMultiplier < 2 {
+		p.levelSizeMultiplier = DefaultLevelSizeMultiplier
+	}
+
+	if p.targetFileSize <= 0 {
+		p.targetFileSize = DefaultTargetFileSize
+	}
+
+	p.compactPointers = make([]string, p.maxLevels)
+
+	return p
+}
+
+func (p *leveledPicker) maxBytesForLevel(level int) int64 {
+	size := p.levelBaseSize
+	for i := 1; i < level; i++ {
+		size *= p.levelSizeMultiplier
+	}
+
+	return size
+}
+
+func (p *leveledPicker) pick(levels [][]*SSTable) *compaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bestLevel := -1
+	var bestScore float64
+
+	for level := 0; level < p.maxLevels-1 && level < len(levels); level++ {
+		var score float64
+		if level == 0 {
+			score = float64(len(levels[0])) / float64(p.l0CompactionTrigger)
+		} else {
+			score = float64(levelSize(levels[level])) / float64(p.maxBytesForLevel(level))
+		}
+
+		if score >= 1 && score > bestScore {
+			bestLevel = level
+			bestScore = score
+		}
+	}
+
+	if bestLevel < 0 {
+		return nil
+	}
+
+	comp := &compaction{
+		level:       bestLevel,
+		outputLevel: bestLevel + 1,
+		maxSize:     p.targetFileSize,
+	}
+
+	if bestLevel == 0 {
+		comp.inputs = make([]*SSTable, len(levels[0]))
+		copy(comp.inputs, levels[0])
+	} else {
+		comp.inputs = []*SSTable{p.pickTable(bestLevel, levels[bestLevel])}
+	}
+
+	minKey, maxKey := keyRange(comp.inputs)
+	if comp.outputLevel < len(levels) {
+		comp.overlaps = overlappingTables(levels[comp.outputLevel], minKey, maxKey)
+	}
+
+	minKey, maxKey = keyRange(comp.inputs, comp.overlaps)
+	comp.bottommost = true
+	for level := comp.outputLevel + 1; level < len(levels); level++ {
+		if len(overlappingTables(levels[level], minKey, maxKey)) > 0 {
+			comp.bottommost = false
+			break
+		}
+	}
+
+	return comp
+}
+
+func (p *leveledPicker) pendingBytes(levels [][]*SSTable) int64 {
+	var pending int64
+	if len(levels[0]) >= p.l0CompactionTrigger {
+		pending += levelSize(levels[0])
+	}
+
+	for level := 1; level < p.maxLevels-1 && level < len(levels); level++ {
+		pending += max(levelSize(levels[level])-p.maxBytesForLevel(level), 0)
+	}
+
+	return pending
+}
+
+func (p *leveledPicker) pickTable(level int, tables []*SSTable) *SSTable {
+	table := tables[0]
+	for _, t := range tables {
+		if t.minKey > p.compactPointers[level] {
+			table = t
+			break
+		}
+	}
+
+	p.compactPointers[level] = table.maxKey
+
+	return table
+}
@@ -0,0 +1,134 @@
+package storage
+
+const (
+	DefaultCompactionMinThreshold = 4
+	DefaultCompactionMaxThreshold = 32
+	DefaultCompactionBucketLow    = 0.5
+	DefaultCompactionBucketHigh   = 1.5
+	DefaultCompactionMinTableSize = 1024 * 1024
+)
+
+type sizeTieredPicker struct {
+	minThreshold int
+	maxThreshold int
+	bucketLow    float64
+	bucketHigh   float64
+	minTableSize int64
+}
+
+func newSizeTieredPicker(opts Options) *sizeTieredPicker {
+	p := &sizeTieredPicker{
+		minThreshold: opts.CompactionMinThreshold,
+		maxThreshold: opts.CompactionMaxThreshold,
+		bucketLow:    opts.CompactionBucketLow,
+		bucketHigh:   opts.CompactionBucketHigh,
+		minTableSize: opts.CompactionMinTableSize,
+	}
+
+	if p.minThreshold < 2 {
+		p.minThreshold = DefaultCompactionMinThreshold
+	}
+
+	if p.maxThreshold < p.minThreshold {
+		p.maxThreshold = max(DefaultCompactionMaxThreshold, p.minThreshold)
+	}
+
+	if p.bucketLow <= 0 {
+		p.bucketLow = DefaultCompactionBucketLow
+	}
+
+	if p.bucketHigh <= 0 {
+		p.bucketHigh = DefaultCompactionBucketHigh
+	}
+
+	if p.minTableSize <= 0 {
+		p.minTableSize = DefaultCompactionMinTableSize
+	}
+
+	return p
+}
+
+// pick looks for the cheapest run of adjacent L0 tables with similar sizes.
+func (p *sizeTieredPicker) pick(levels [][]*SSTable) *compaction {
+	tables := levels[0]
+
+	var best []*SSTable
+	var bestAvg float64
+	bestStart := -1
+
+	for start := range tables {
+		var total int64
+		end := start
+
+		for end < len(tables) && end-start < p.maxThreshold {
+			size := max(tables[end].size, p.minTableSize)
+			if end > start {
+				avg := float64(total) / float64(end-start)
+				if float64(size) < avg*p.bucketLow || float64(size) > avg*p.bucketHigh {
+					break
+				}
+			}
+
+			total += size
+			end++
+		}
+
+		if end-start >= p.minThreshold {
+			avg := float64(total) / float64(end-start)
+			if best == nil || avg < bestAvg {
+				best = tables[start:end]
+				bestAvg = avg
+				bestStart = start
+			}
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	inputs := make([]*SSTable, len(best))
+	copy(inputs, best)
+
+	// Tables before the run may still hold versions older than the run, and
+	// so may tables after it that overlap the run in sequence numbers.
+	bottommost := bestStart == 0
+	minKey, maxKey := keyRange(inputs)
+	var largestSeq uint64
+	for _, t := range inputs {
+		largestSeq = max(largestSeq, t.largestSeq)
+	}
+
+	after := overlappingTables(tables[bestStart+len(inputs):], minKey, maxKey)
+	for _, t := range after {
+		if t.smallestSeq <= largestSeq {
+			bottommost = false
+		}
+	}
+
+	outside := append(overlappingTables(tables[:bestStart], minKey, maxKey), after...)
+	for _, level := range levels[1:] {
+		if len(level) > 0 {
+			bottommost = false
+		}
+
+		outside = append(outside, overlappingTables(level, minKey, maxKey)...)
+	}
+
+	return &compaction{
+		level:       0,
+		outputLevel: 0,
+		inputs:      inputs,
+		bottommost:  bottommost,
+		outside:     outside,
+	}
+}
+
+func (p *sizeTieredPicker) pendingBytes(levels [][]*SSTable) int64 {
+	comp := p.pick(levels)
+	if comp == nil {
+		return 0
+	}
+
+	return levelSize(comp.inputs)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompactionKeepsOperandsOfInterleavedBase(t *testing.T) {
+	s := newTestStorage(t, Options{
+		MergeOperator:          NewAppendOperator([]byte(",")),
+		CompactionMinThreshold: 2,
+		CompactionMaxThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	// The table with the middle version sorts before the run holding the
+	// base and the operand, and is too large to join it.
+	var middle []decodedEntry
+	for i := 0; i < 200; i++ {
+		middle = append(middle, decodedEntry{key: []byte(fmt.Sprintf("a%03d", i)), value: []byte("v"), kind: entryKindPut, seq: nextTestSeq(s)})
+	}
+	baseSeq := nextTestSeq(s)
+	middle = append(middle, decodedEntry{key: []byte("k"), value: []byte("b"), kind: entryKindPut, seq: nextTestSeq(s)})
+	operandSeq := nextTestSeq(s)
+
+	addTestTable(t, s, 0, middle...)
+	base := addTestTable(t, s, 0,
+		decodedEntry{key: []byte("k"), value: []byte("a"), kind: entryKindPut, seq: baseSeq},
+		decodedEntry{key: []byte("m"), value: []byte("v"), kind: entryKindPut, seq: nextTestSeq(s)},
+	)
+	operand := addTestTable(t, s, 0,
+		decodedEntry{key: []byte("k"), value: []byte("x"), kind: entryKindMerge, seq: operandSeq},
+		decodedEntry{key: []byte("n"), value: []byte("v"), kind: entryKindPut, seq: nextTestSeq(s)},
+	)
+
+	if value, _ := mustGet(t, s, "k"); value != "b,x" {
+		t.Fatalf("before compaction got %q, want %q", value, "b,x")
+	}
+
+	s.tablesMutex.RLock()
+	comp := s.compactor.picker.pick(s.levels)
+	s.tablesMutex.RUnlock()
+
+	if comp == nil || len(comp.inputs) != 2 || comp.inputs[0] != base || comp.inputs[1] != operand {
+		t.Fatalf("picked %+v, want the base and the operand tables", comp)
+	}
+
+	err := s.compactor.compact(comp)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	if value, _ := mustGet(t, s, "k"); value != "b,x" {
+		t.Fatalf("after compaction got %q, want %q", value, "b,x")
+	}
+}
+
+func TestCompactionFoldsOperandsOntoBottommostBase(t *testing.T) {
+	s := newTestStorage(t, Options{
+		MergeOperator:          NewAppendOperator([]byte(",")),
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	addTestTable(t, s, 0, decodedEntry{key: []byte("k"), value: []byte("a"), kind: entryKindPut, seq: nextTestSeq(s)})
+	addTestTable(t, s, 0, decodedEntry{key: []byte("k"), value: []byte("x"), kind: entryKindMerge, seq: nextTestSeq(s)})
+
+	compacted, err := s.compactor.compactOnce()
+	if err != nil || !compacted {
+		t.Fatalf("compactOnce = %v, %v", compacted, err)
+	}
+
+	if len(s.levels[0]) != 1 {
+		t.Fatalf("got %d tables, want 1", len(s.levels[0]))
+	}
+
+	e, found, err := s.levels[0][0].getEntry("k", s.commits.visible())
+	if err != nil || !found {
+		t.Fatalf("getEntry = %v, %v", found, err)
+	}
+
+	if e.kind != entryKindPut || string(e.value) != "a,x" {
+		t.Fatalf("got kind %d value %q, want a put of %q", e.kind, e.value, "a,x")
+	}
+}
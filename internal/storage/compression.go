@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Compression is the codec of a data block. It is stored in every block, so
+// tables and blocks written with different settings can be read together.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionFlate
+	CompressionZlib
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionZlib:
+		return "zlib"
+	default:
+		return fmt.Sprintf("compression(%d)", uint8(c))
+	}
+}
+
+type blockCompressor struct {
+	compression Compression
+	buf         bytes.Buffer
+	flate       *flate.Writer
+	zlib        *zlib.Writer
+}
+
+// compress returns the block encoded with the configured codec, blocks that do
+// not shrink by at least an eighth are kept uncompressed.
+func (c *blockCompressor) compress(block []byte) (Compression, []byte, error) {
+	if c.compression == CompressionNone {
+		return CompressionNone, block, nil
+	}
+
+	c.buf.Reset()
+
+	var w io.WriteCloser
+	switch c.compression {
+	case CompressionFlate:
+		if c.flate == nil {
+			fw, err := flate.NewWriter(&c.buf, flate.DefaultCompression)
+			if err != nil {
+				return 0, nil, err
+			}
+			c.flate = fw
+		} else {
+			c.flate.Reset(&c.buf)
+		}
+		w = c.flate
+	case CompressionZlib:
+		if c.zlib == nil {
+			c.zlib = zlib.NewWriter(&c.buf)
+		} else {
+			c.zlib.Reset(&c.buf)
+		}
+		w = c.zlib
+	default:
+		return 0, nil, fmt.Errorf("sstable: unknown compression %v", c.compression)
+	}
+
+	_, err := w.Write(block)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if c.buf.Len() >= len(block)-len(block)/8 {
+		return CompressionNone, block, nil
+	}
+
+	return c.compression, c.buf.Bytes(), nil
+}
+
+func decompressBlock(compression Compression, data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CompressionZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	default:
+		return nil, fmt.Errorf("unknown compression %v", compression)
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
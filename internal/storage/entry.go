@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	MaxKeySize   = 1 << 20
+	MaxValueSize = math.MaxUint32
+)
+
+var (
+	ErrKeyTooLarge   = errors.New("storage: key is too large")
+	ErrValueTooLarge = errors.New("storage: value is too large")
+)
+
+// Entry kinds of the varint format. A merge entry holds an operand that the
+// merge operator folds into the older versions of its key.
+const (
+	entryKindPut         byte = 1
+	entryKindDelete      byte = 2
+	entryKindMerge       byte = 3
+	entryKindRangeDelete byte = 4
+)
+
+// Entry layout since format version 4, all numbers are uvarints:
+//
+//	shared key length, unshared key length, value length, kind byte, flags,
+//	sequence number, expiration (since version 5), unshared key bytes, value
+//
+// The expiration is a unix time in seconds, 0 means the entry never expires.
+// Version 3 used fixed size fields and versions before it did not compress
+// keys, their entries are decoded by decodePrefixEntry and decodeLegacyEntry.
+const (
+	entryHeaderSize       = 12
+	entrySeqSize          = 8
+	prefixEntryHeaderSize = 22
+)
+
+const (
+	entryTombstone uint16 = 1
+	// entryHasSeq marks entries written with a sequence number. Entries of
+	// tables written before sequence numbers existed inherit the largest
+	// sequence number of their table.
+	entryHasSeq uint16 = 1 << 15
+)
+
+type decodedEntry struct {
+	key       []byte
+	value     []byte
+	flags     uint32
+	kind      byte
+	seq       uint64
+	expiresAt int64
+}
+
+// expire turns an entry whose time to live has passed into a tombstone, so it
+// still shadows the older versions of its key and compaction drops its value.
+func (e *decodedEntry) expire() {
+	if e.expiresAt != 0 && e.expiresAt <= time.Now().Unix() {
+		e.value = nil
+		e.flags = 0
+		e.kind = entryKindDelete
+		e.expiresAt = 0
+	}
+}
+
+func checkEntrySize(key string, value []byte) error {
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	if int64(len(value)) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	return nil
+}
+
+func appendEntry(buf []byte, key string, shared int, value []byte, flags uint32, kind byte, seq uint64, expiresAt int64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(shared))
+	buf = binary.AppendUvarint(buf, uint64(len(key)-shared))
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	buf = append(buf, kind)
+	buf = binary.AppendUvarint(buf, uint64(flags))
+	buf = binary.AppendUvarint(buf, seq)
+	buf = binary.AppendUvarint(buf, uint64(expiresAt))
+	buf = append(buf, key[shared:]...)
+	buf = append(buf, value...)
+
+	return buf
+}
+
+func sharedPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
+
+// decodeEntry decodes the entry at pos and returns the position of the next one.
+// Keys of prefix compressed blocks are returned without the shared prefix,
+// whose length is returned as well.
+func (t *SSTable) decodeEntry(block []byte, pos int64, e *decodedEntry) (int64, int, error) {
+	switch {
+	case t.formatVersion < prefixTableFormatVersion:
+		pos, err := t.decodeLegacyEntry(block, pos, e)
+		return pos, 0, err
+	case t.formatVersion == prefixTableFormatVersion:
+		return decodePrefixEntry(block, pos, e)
+	}
+
+	d := entryDecoder{buf: block, pos: pos}
+	shared := d.uvarint()
+	kLen := d.uvarint()
+	vLen := d.uvarint()
+	kind := d.byte()
+	flags := d.uvarint()
+	seq := d.uvarint()
+	var expiresAt uint64
+	if t.formatVersion >= ttlTableFormatVersion {
+		expiresAt = d.uvarint()
+	}
+	pos = d.pos
+
+	if d.err != nil {
+		return pos, 0, d.err
+	}
+
+	switch kind {
+	case entryKindPut, entryKindDelete, entryKindMerge:
+		e.kind = kind
+	default:
+		return pos, 0, fmt.Errorf("unsupported entry kind %d", kind)
+	}
+
+	rest := uint64(int64(len(block)) - pos)
+	if shared > MaxKeySize || flags > math.MaxUint32 || expiresAt > math.MaxInt64 || kLen > rest || vLen > rest-kLen {
+		return pos, 0, errEntryOutOfBounds
+	}
+
+	e.flags = uint32(flags)
+	e.seq = seq
+	e.expiresAt = int64(expiresAt)
+
+	e.key = block[pos : pos+int64(kLen)]
+	pos += int64(kLen)
+
+	e.value = block[pos : pos+int64(vLen)]
+	pos += int64(vLen)
+	e.expire()
+
+	return pos, int(shared), nil
+}
+
+func decodePrefixEntry(block []byte, pos int64, e *decodedEntry) (int64, int, error) {
+	blockLen := int64(len(block))
+	if blockLen < pos+prefixEntryHeaderSize {
+		return pos, 0, errEntryOutOfBounds
+	}
+
+	shared := int(binary.BigEndian.Uint16(block[pos : pos+2]))
+	kLen := binary.BigEndian.Uint16(block[pos+2 : pos+4])
+	vLen := binary.BigEndian.Uint32(block[pos+4 : pos+8])
+	e.flags = binary.BigEndian.Uint32(block[pos+8 : pos+12])
+	kind := binary.BigEndian.Uint16(block[pos+12 : pos+14])
+	e.seq = binary.BigEndian.Uint64(block[pos+14 : pos+22])
+	e.kind = legacyEntryKind(kind)
+	pos += prefixEntryHeaderSize
+
+	if blockLen < pos+int64(kLen)+int64(vLen) {
+		return pos, 0, errEntryOutOfBounds
+	}
+
+	e.key = block[pos : pos+int64(kLen)]
+	pos += int64(kLen)
+
+	e.value = block[pos : pos+int64(vLen)]
+	pos += int64(vLen)
+
+	return pos, shared, nil
+}
+
+func (t *SSTable) decodeLegacyEntry(block []byte, pos int64, e *decodedEntry) (int64, error) {
+	blockLen := int64(len(block))
+	if blockLen < pos+entryHeaderSize {
+		return pos, errEntryOutOfBounds
+	}
+
+	kLen := binary.BigEndian.Uint16(block[pos : pos+2])
+	vLen := binary.BigEndian.Uint32(block[pos+2 : pos+6])
+	e.flags = binary.BigEndian.Uint32(block[pos+6 : pos+10])
+	kind := binary.BigEndian.Uint16(block[pos+10 : pos+12])
+	pos += entryHeaderSize
+
+	e.kind = legacyEntryKind(kind)
+	e.seq = t.largestSeq
+	if kind&entryHasSeq != 0 {
+		if blockLen < pos+entrySeqSize {
+			return pos, errEntryOutOfBounds
+		}
+
+		e.seq = binary.BigEndian.Uint64(block[pos : pos+entrySeqSize])
+		pos += entrySeqSize
+	}
+
+	if blockLen < pos+int64(kLen)+int64(vLen) {
+		return pos, errEntryOutOfBounds
+	}
+
+	e.key = block[pos : pos+int64(kLen)]
+	pos += int64(kLen)
+
+	e.value = block[pos : pos+int64(vLen)]
+	pos += int64(vLen)
+
+	return pos, nil
+}
+
+func legacyEntryKind(kind uint16) byte {
+	if kind&entryTombstone != 0 {
+		return entryKindDelete
+	}
+
+	return entryKindPut
+}
+
+type entryDecoder struct {
+	buf []byte
+	pos int64
+	err error
+}
+
+func (d *entryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.err = errEntryOutOfBounds
+		return 0
+	}
+	d.pos += int64(n)
+
+	return v
+}
+
+func (d *entryDecoder) lengthPrefixed() string {
+	l := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	if l > uint64(int64(len(d.buf))-d.pos) {
+		d.err = errEntryOutOfBounds
+		return ""
+	}
+	d.pos += int64(l)
+
+	return string(d.buf[d.pos-int64(l) : d.pos])
+}
+
+func (d *entryDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+
+	if d.pos >= int64(len(d.buf)) {
+		d.err = errEntryOutOfBounds
+		return 0
+	}
+	d.pos++
+
+	return d.buf[d.pos-1]
+}
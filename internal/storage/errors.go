@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorruption matches every CorruptionError with errors.Is.
+var ErrCorruption = errors.New("storage: data corruption")
+
+// CorruptionError reports on-disk data that failed a checksum or does not
+// decode, Offset is the position in the file the damaged part starts at.
+type CorruptionError struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("storage: corrupted %s at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+func (t *SSTable) corruption(offset int64, format string, args ...any) error {
+	return &CorruptionError{
+		Path:   t.path,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
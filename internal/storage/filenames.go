@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const tableSuffix = ".sst"
+
+func tableFileName(dir string, fileNum uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d%s", fileNum, tableSuffix))
+}
+
+func parseFileNumber(name string, prefix string, suffix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+
+	num := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+	fileNum, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return fileNum, true
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"bytes"
+	"slices"
+)
+
+// Iterator walks the live keys in [lower, upper) in order, tombstones and
+// shadowed versions are hidden. It sees the state at the moment it was created.
+// An unpositioned iterator starts from the first key on Next and from the
+// last one on Prev.
+type Iterator struct {
+	merged          *mergingIterator
+	mergeOperator   MergeOperator
+	rangeTombstones []rangeTombstone
+	tables          []*SSTable
+	seq             uint64
+	lower           []byte
+	upper           []byte
+	current         decodedEntry
+	versions        []decodedEntry
+	valid           bool
+	positioned      bool
+	reverse         bool
+	err             error
+}
+
+// NewIterator returns an iterator over keys in [lower, upper), an empty upper
+// means no upper bound. It must be closed.
+func (s *Storage) NewIterator(lower string, upper string) *Iterator {
+	return s.newIterator(lower, upper, s.commits.visible(), nil)
+}
+
+func (sn *Snapshot) NewIterator(lower string, upper string) *Iterator {
+	return sn.storage.newIterator(lower, upper, sn.seq, nil)
+}
+
+// ScanPrefix calls fn for every live key starting with prefix in key order
+// until fn returns false. With a prefix extractor configured, tables whose
+// prefix filter rules the prefix out are not read at all.
+func (s *Storage) ScanPrefix(prefix string, fn func(key string, value []byte, flags uint32) bool) error {
+	mayContain := func(t *SSTable) bool {
+		return t.mayContainPrefix(s.tableOptions.PrefixExtractor, prefix)
+	}
+
+	it := s.newIterator(prefix, prefixSuccessor(prefix), s.commits.visible(), mayContain)
+
+	for it.Next() {
+		if !fn(it.Key(), it.Value(), it.Flags()) {
+			break
+		}
+	}
+
+	err := it.Err()
+	closeErr := it.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// prefixSuccessor returns the smallest key greater than every key starting
+// with prefix, or an empty string when there is none.
+func prefixSuccessor(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+
+	return ""
+}
+
+func (s *Storage) newIterator(lower string, upper string, seq uint64, mayContain func(t *SSTable) bool) *Iterator {
+	var iters []internalIterator
+
+	// Memtables go first, from the active to the immutable ones: a memtable
+	// becomes immutable before it is replaced and is dropped only once its
+	// tables are installed, so nothing is missed, while versions read twice
+	// are dropped by resolve.
+	for _, shard := range s.shards {
+		iters = append(iters, shard.memtable().newIterator(seq))
+	}
+
+	s.immutablesMutex.RLock()
+	for _, imm := range s.immutables {
+		for _, skipList := range imm.skipLists {
+			iters = append(iters, skipList.newIterator(seq))
+		}
+	}
+	s.immutablesMutex.RUnlock()
+
+	var tables []*SSTable
+
+	// Range tombstones are taken from every table, the prefix filter of a
+	// table says nothing about the ranges it deletes.
+	s.tablesMutex.RLock()
+	rangeTombstones := s.rangeTombstonesIn(lower, upper, seq)
+	for _, level := range s.levels {
+		for _, t := range level {
+			if (upper != "" && t.minKey >= upper) || t.maxKey < lower {
+				continue
+			}
+
+			if mayContain != nil && !mayContain(t) {
+				continue
+			}
+
+			t.ref()
+			tables = append(tables, t)
+			iters = append(iters, t.newTableIterator(true))
+		}
+	}
+	s.tablesMutex.RUnlock()
+
+	it := &Iterator{
+		merged:          newMergingIterator(iters),
+		mergeOperator:   s.mergeOperator,
+		rangeTombstones: rangeTombstones,
+		tables:          tables,
+		seq:             seq,
+		lower:           []byte(lower),
+	}
+
+	if upper != "" {
+		it.upper = []byte(upper)
+	}
+
+	return it
+}
+
+// Seek positions the iterator at the first key at or after key.
+func (it *Iterator) Seek(key string) bool {
+	target := []byte(key)
+	if bytes.Compare(target, it.lower) < 0 {
+		target = it.lower
+	}
+
+	it.positioned = true
+	it.reverse = false
+	it.merged.SeekGE(target)
+
+	return it.findNext()
+}
+
+func (it *Iterator) SeekToFirst() bool {
+	return it.Seek(string(it.lower))
+}
+
+func (it *Iterator) SeekToLast() bool {
+	it.positioned = true
+	it.reverse = true
+
+	if it.upper != nil {
+		it.merged.SeekLT(it.upper)
+	} else {
+		it.merged.SeekToLast()
+	}
+
+	return it.findPrev()
+}
+
+func (it *Iterator) Next() bool {
+	if !it.positioned {
+		return it.SeekToFirst()
+	}
+
+	if !it.valid {
+		return false
+	}
+
+	if it.reverse {
+		it.reverse = false
+		successor := make([]byte, len(it.current.key)+1)
+		copy(successor, it.current.key)
+		it.merged.SeekGE(successor)
+	}
+
+	return it.findNext()
+}
+
+func (it *Iterator) Prev() bool {
+	if !it.positioned {
+		return it.SeekToLast()
+	}
+
+	if !it.valid {
+		return false
+	}
+
+	if !it.reverse {
+		it.reverse = true
+		it.merged.SeekLT(it.current.key)
+	}
+
+	return it.findPrev()
+}
+
+// findNext resolves the key groups forward until one has a visible value, the
+// newest visible version of a key comes first.
+func (it *Iterator) findNext() bool {
+	for it.merged.Valid() {
+		e := it.merged.Entry()
+		if it.upper != nil && bytes.Compare(e.key, it.upper) >= 0 {
+			break
+		}
+
+		key := e.key
+		it.versions = it.versions[:0]
+
+		for it.merged.Valid() && bytes.Equal(it.merged.Entry().key, key) {
+			e = it.merged.Entry()
+			if e.seq <= it.seq {
+				it.versions = append(it.versions, *e)
+			}
+			it.merged.Next()
+		}
+
+		if it.resolve() {
+			return true
+		}
+
+		if it.err != nil {
+			break
+		}
+	}
+
+	return it.stop()
+}
+
+// findPrev is findNext backwards, versions of a key come oldest first.
+func (it *Iterator) findPrev() bool {
+	for it.merged.Valid() {
+		e := it.merged.Entry()
+		if bytes.Compare(e.key, it.lower) < 0 {
+			break
+		}
+
+		key := e.key
+		it.versions = it.versions[:0]
+
+		for it.merged.Valid() && bytes.Equal(it.merged.Entry().key, key) {
+			e = it.merged.Entry()
+			if e.seq <= it.seq {
+				it.versions = append(it.versions, *e)
+			}
+			it.merged.Prev()
+		}
+
+		slices.Reverse(it.versions)
+		if it.resolve() {
+			return true
+		}
+
+		if it.err != nil {
+			break
+		}
+	}
+
+	return it.stop()
+}
+
+// resolve makes the visible versions of the key group, newest first, the
+// current entry and tells whether the key has a value.
+func (it *Iterator) resolve() bool {
+	if len(it.versions) == 0 {
+		return false
+	}
+
+	versions := withRangeTombstones(uniqueVersions(it.versions), it.rangeTombstones)
+	e, found, err := resolveVersions(it.mergeOperator, string(it.versions[0].key), versions)
+	if err != nil {
+		it.err = err
+		it.valid = false
+		return false
+	}
+
+	it.current = e
+	it.valid = found
+
+	return found
+}
+
+// uniqueVersions drops repeated versions, newest first, in place. A memtable
+// is seen twice by an iterator created while it is rotated, and so are the
+// entries of an immutable memtable whose tables are being installed, but a
+// merge operand must be folded once. Compaction may already have folded such
+// an operand into a value with the same sequence, which is kept instead.
+func uniqueVersions(versions []decodedEntry) []decodedEntry {
+	n := 0
+	for _, e := range versions {
+		if n > 0 && versions[n-1].seq == e.seq {
+			if versions[n-1].kind == entryKindMerge && e.kind != entryKindMerge {
+				versions[n-1] = e
+			}
+			continue
+		}
+
+		versions[n] = e
+		n++
+	}
+
+	return versions[:n]
+}
+
+func (it *Iterator) stop() bool {
+	it.valid = false
+	if it.err == nil {
+		it.err = it.merged.Err()
+	}
+
+	return false
+}
+
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+func (it *Iterator) Key() string {
+	return string(it.current.key)
+}
+
+func (it *Iterator) Value() []byte {
+	return it.current.value
+}
+
+func (it *Iterator) Flags() uint32 {
+	return it.current.flags
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Close() error {
+	var err error
+	for _, t := range it.tables {
+		unrefErr := t.unref()
+		if unrefErr != nil && err == nil {
+			err = unrefErr
+		}
+	}
+	it.tables = nil
+
+	return err
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// newIteratorTestStorage spreads the versions of the keys over two tables and
+// the memtable and returns the live values.
+func newIteratorTestStorage(t *testing.T) (*Storage, map[string]string) {
+	t.Helper()
+
+	s := newTestStorage(t, Options{BlockSize: 64})
+	live := make(map[string]string)
+
+	set := func(key, value string) {
+		err := s.Set(key, []byte(value), 0)
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		live[key] = value
+	}
+
+	del := func(key string) {
+		err := s.Delete(key)
+		if err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		delete(live, key)
+	}
+
+	flush := func() {
+		err := s.flush()
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+
+	for i := 0; i < 30; i += 2 {
+		set(fmt.Sprintf("k%02d", i), "t1")
+	}
+	flush()
+
+	for i := 0; i < 30; i += 4 {
+		set(fmt.Sprintf("k%02d", i), "t2")
+	}
+	del("k06")
+	flush()
+
+	for i := 1; i < 10; i += 2 {
+		set(fmt.Sprintf("k%02d", i), "m")
+	}
+	del("k08")
+	del("k28")
+	set("k12", "m")
+
+	return s, live
+}
+
+func iteratorKeys(it *Iterator, step func() bool) []string {
+	var keys []string
+	for step() {
+		keys = append(keys, it.Key()+"="+string(it.Value()))
+	}
+
+	return keys
+}
+
+func expectedKeys(live map[string]string, lower, upper string) []string {
+	var keys []string
+	for key, value := range live {
+		if key >= lower && (upper == "" || key < upper) {
+			keys = append(keys, key+"="+value)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestIteratorScansMemtableAndTables(t *testing.T) {
+	s, live := newIteratorTestStorage(t)
+
+	for _, bounds := range [][2]string{{"", ""}, {"k05", "k15"}, {"k06", "k29"}} {
+		want := expectedKeys(live, bounds[0], bounds[1])
+
+		it := s.NewIterator(bounds[0], bounds[1])
+		got := iteratorKeys(it, it.Next)
+		if it.Err() != nil || !slices.Equal(got, want) {
+			t.Fatalf("%v forward got %v, want %v: %v", bounds, got, want, it.Err())
+		}
+		it.Close()
+
+		it = s.NewIterator(bounds[0], bounds[1])
+		got = iteratorKeys(it, it.Prev)
+		slices.Reverse(want)
+		if it.Err() != nil || !slices.Equal(got, want) {
+			t.Fatalf("%v backward got %v, want %v: %v", bounds, got, want, it.Err())
+		}
+		it.Close()
+	}
+}
+
+func TestIteratorSeekAndChangeDirection(t *testing.T) {
+	s, _ := newIteratorTestStorage(t)
+
+	it := s.NewIterator("", "")
+	defer it.Close()
+
+	// The iterator keeps the state it was created with.
+	err := s.Set("k07", []byte("later"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	steps := []struct {
+		name string
+		step func() bool
+		want string
+	}{
+		{"Seek(k06)", func() bool { return it.Seek("k06") }, "k07=m"},
+		{"Prev", it.Prev, "k05=m"},
+		{"Prev", it.Prev, "k04=t2"},
+		{"Next", it.Next, "k05=m"},
+		{"Next", it.Next, "k07=m"},
+		{"Next", it.Next, "k09=m"},
+		{"Next", it.Next, "k10=t1"},
+		{"Seek(k27)", func() bool { return it.Seek("k27") }, ""},
+		{"SeekToLast", it.SeekToLast, "k26=t1"},
+		{"Prev", it.Prev, "k24=t2"},
+		{"SeekToFirst", it.SeekToFirst, "k00=t2"},
+		{"Prev", it.Prev, ""},
+	}
+
+	for _, step := range steps {
+		valid := step.step()
+
+		got := ""
+		if valid {
+			got = it.Key() + "=" + string(it.Value())
+		}
+
+		if got != step.want || valid != it.Valid() {
+			t.Fatalf("%s got %q, want %q", step.name, got, step.want)
+		}
+	}
+
+	if it.Err() != nil {
+		t.Fatalf("Err: %v", it.Err())
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type legacyTable struct {
+	path      string
+	timestamp int64
+	level     int
+}
+
+// migrateLegacyTables registers tables of a data directory written before the
+// manifest existed. Legacy files are hard linked under their new names and are
+// removed as obsolete only once the new manifest is in place.
+func (s *Storage) migrateLegacyTables() (*manifestState, error) {
+	st := &manifestState{nextFileNumber: 1}
+
+	files, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var legacy []legacyTable
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), tableSuffix) {
+			continue
+		}
+
+		path := filepath.Join(s.dataDir, f.Name())
+		ts, level, err := parseTableFileName(path)
+		if err != nil {
+			continue
+		}
+
+		legacy = append(legacy, legacyTable{path: path, timestamp: ts, level: level})
+	}
+
+	if len(legacy) == 0 {
+		return st, nil
+	}
+
+	log.Printf("Migrating %d legacy tables to the manifest...", len(legacy))
+
+	sort.SliceStable(legacy, func(i, j int) bool {
+		if legacy[i].timestamp != legacy[j].timestamp {
+			return legacy[i].timestamp < legacy[j].timestamp
+		}
+
+		return legacy[i].path < legacy[j].path
+	})
+
+	for _, l := range legacy {
+		fileNum := st.nextFileNumber
+		st.nextFileNumber++
+		st.lastSequence++
+
+		path := tableFileName(s.dataDir, fileNum)
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		err = os.Link(l.path, path)
+		if err != nil {
+			return nil, err
+		}
+
+		table, err := OpenSSTable(path, s.tableOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		meta := table.tableMeta
+		meta.fileNum = fileNum
+		meta.smallestSeq = st.lastSequence
+		meta.largestSeq = st.lastSequence
+
+		err = table.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		st.apply(&versionEdit{newTables: []levelTable{{level: l.level, meta: meta}}})
+	}
+
+	return st, nil
+}
+
+// parseTableFileName extracts the ordering timestamp and the level from legacy
+// "<prefix>.<timestamp>.sst" and "<prefix>.<timestamp>.<level>.sst" file names.
+func parseTableFileName(path string) (int64, int, error) {
+	fields := strings.Split(strings.TrimSuffix(filepath.Base(path), tableSuffix), ".")
+	if len(fields) != 2 && len(fields) != 3 {
+		return 0, 0, fmt.Errorf("unexpected table file name %s", path)
+	}
+
+	ts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(fields) == 2 {
+		return ts, 0, nil
+	}
+
+	level, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ts, level, nil
+}
@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	manifestFormatVersion = 1
+	manifestPrefix        = "MANIFEST-"
+	currentFileName       = "CURRENT"
+	maxManifestSize       = 4 * 1024 * 1024
+)
+
+const (
+	tagFormatVersion uint64 = iota + 1
+	tagWALSegment
+	tagNextFileNumber
+	tagLastSequence
+	tagDeletedTable
+	tagNewTable
+)
+
+type tableMeta struct {
+	fileNum     uint64
+	size        int64
+	minKey      string
+	maxKey      string
+	smallestSeq uint64
+	largestSeq  uint64
+}
+
+type levelTable struct {
+	level int
+	meta  tableMeta
+}
+
+type levelFile struct {
+	level   int
+	fileNum uint64
+}
+
+type versionEdit struct {
+	formatVersion     uint64
+	walSegment        uint64
+	hasWALSegment     bool
+	nextFileNumber    uint64
+	hasNextFileNumber bool
+	lastSequence      uint64
+	hasLastSequence   bool
+	deletedTables     []levelFile
+	newTables         []levelTable
+}
+
+func (e *versionEdit) setWALSegment(segmentID uint64) {
+	e.walSegment = segmentID
+	e.hasWALSegment = true
+}
+
+func (e *versionEdit) setNextFileNumber(fileNum uint64) {
+	e.nextFileNumber = fileNum
+	e.hasNextFileNumber = true
+}
+
+func (e *versionEdit) setLastSequence(seq uint64) {
+	e.lastSequence = seq
+	e.hasLastSequence = true
+}
+
+func (e *versionEdit) addTable(level int, meta tableMeta) {
+	e.newTables = append(e.newTables, levelTable{level: level, meta: meta})
+}
+
+func (e *versionEdit) deleteTable(level int, fileNum uint64) {
+	e.deletedTables = append(e.deletedTables, levelFile{level: level, fileNum: fileNum})
+}
+
+func (e *versionEdit) encode(buf []byte) []byte {
+	if e.formatVersion != 0 {
+		buf = binary.AppendUvarint(buf, tagFormatVersion)
+		buf = binary.AppendUvarint(buf, e.formatVersion)
+	}
+
+	if e.hasWALSegment {
+		buf = binary.AppendUvarint(buf, tagWALSegment)
+		buf = binary.AppendUvarint(buf, e.walSegment)
+	}
+
+	if e.hasNextFileNumber {
+		buf = binary.AppendUvarint(buf, tagNextFileNumber)
+		buf = binary.AppendUvarint(buf, e.nextFileNumber)
+	}
+
+	if e.hasLastSequence {
+		buf = binary.AppendUvarint(buf, tagLastSequence)
+		buf = binary.AppendUvarint(buf, e.lastSequence)
+	}
+
+	for _, d := range e.deletedTables {
+		buf = binary.AppendUvarint(buf, tagDeletedTable)
+		buf = binary.AppendUvarint(buf, uint64(d.level))
+		buf = binary.AppendUvarint(buf, d.fileNum)
+	}
+
+	for _, n := range e.newTables {
+		buf = binary.AppendUvarint(buf, tagNewTable)
+		buf = binary.AppendUvarint(buf, uint64(n.level))
+		buf = binary.AppendUvarint(buf, n.meta.fileNum)
+		buf = binary.AppendUvarint(buf, uint64(n.meta.size))
+		buf = appendLengthPrefixed(buf, n.meta.minKey)
+		buf = appendLengthPrefixed(buf, n.meta.maxKey)
+		buf = binary.AppendUvarint(buf, n.meta.smallestSeq)
+		buf = binary.AppendUvarint(buf, n.meta.largestSeq)
+	}
+
+	return buf
+}
+
+func decodeVersionEdit(payload []byte) (*versionEdit, error) {
+	d := &manifestDecoder{buf: payload}
+	e := &versionEdit{}
+
+	for len(d.buf) > 0 && d.err == nil {
+		switch tag := d.uvarint(); tag {
+		case tagFormatVersion:
+			e.formatVersion = d.uvarint()
+		case tagWALSegment:
+			e.setWALSegment(d.uvarint())
+		case tagNextFileNumber:
+			e.setNextFileNumber(d.uvarint())
+		case tagLastSequence:
+			e.setLastSequence(d.uvarint())
+		case tagDeletedTable:
+			level := int(d.uvarint())
+			e.deleteTable(level, d.uvarint())
+		case tagNewTable:
+			var n levelTable
+			n.level = int(d.uvarint())
+			n.meta.fileNum = d.uvarint()
+			n.meta.size = int64(d.uvarint())
+			n.meta.minKey = d.lengthPrefixed()
+			n.meta.maxKey = d.lengthPrefixed()
+			n.meta.smallestSeq = d.uvarint()
+			n.meta.largestSeq = d.uvarint()
+			e.newTables = append(e.newTables, n)
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("manifest: unknown tag %d", tag)
+			}
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return e, nil
+}
+
+type manifestDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *manifestDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = fmt.Errorf("manifest: malformed varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *manifestDecoder) lengthPrefixed() string {
+	l := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	if uint64(len(d.buf)) < l {
+		d.err = fmt.Errorf("manifest: string is out of bounds")
+		return ""
+	}
+
+	v := string(d.buf[:l])
+	d.buf = d.buf[l:]
+
+	return v
+}
+
+func appendLengthPrefixed(buf []byte, v string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+type manifestState struct {
+	walSegment     uint64
+	nextFileNumber uint64
+	lastSequence   uint64
+	levels         []map[uint64]tableMeta
+}
+
+func (st *manifestState) apply(e *versionEdit) {
+	if e.hasWALSegment {
+		st.walSegment = e.walSegment
+	}
+
+	if e.hasNextFileNumber {
+		st.nextFileNumber = e.nextFileNumber
+	}
+
+	if e.hasLastSequence {
+		st.lastSequence = e.lastSequence
+	}
+
+	for _, d := range e.deletedTables {
+		if d.level < len(st.levels) {
+			delete(st.levels[d.level], d.fileNum)
+		}
+	}
+
+	for _, n := range e.newTables {
+		for len(st.levels) <= n.level {
+			st.levels = append(st.levels, make(map[uint64]tableMeta))
+		}
+		st.levels[n.level][n.meta.fileNum] = n.meta
+	}
+}
+
+type manifest struct {
+	dir     string
+	fileNum uint64
+	f       *os.File
+	writer  *bufio.Writer
+	size    int64
+	buf     []byte
+}
+
+// readManifest replays the manifest CURRENT points to. It reports false when the
+// directory has no manifest yet.
+func readManifest(dir string) (*manifestState, uint64, bool, error) {
+	current, err := os.ReadFile(filepath.Join(dir, currentFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, false, nil
+		}
+
+		return nil, 0, false, err
+	}
+
+	name := strings.TrimSpace(string(current))
+	fileNum, ok := parseFileNumber(name, manifestPrefix, "")
+	if !ok {
+		return nil, 0, false, fmt.Errorf("manifest: CURRENT points to unexpected file %q", name)
+	}
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer f.Close()
+
+	st := &manifestState{}
+	first := true
+
+	_, err = readRecords(f, func(payload []byte) error {
+		e, err := decodeVersionEdit(payload)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errTornRecord, err)
+		}
+
+		if first && e.formatVersion != manifestFormatVersion {
+			return fmt.Errorf("manifest: unsupported format version %d", e.formatVersion)
+		}
+		first = false
+
+		st.apply(e)
+
+		return nil
+	})
+
+	// A torn record can only be the edit that was being written during a crash,
+	// it was never acknowledged so the state before it is the valid one.
+	if err != nil && !errors.Is(err, errTornRecord) {
+		return nil, 0, false, fmt.Errorf("manifest %s: %w", name, err)
+	}
+
+	if first {
+		return nil, 0, false, fmt.Errorf("manifest %s is empty", name)
+	}
+
+	return st, fileNum, true, nil
+}
+
+func createManifest(dir string, fileNum uint64, snapshot *versionEdit) (*manifest, error) {
+	path := filepath.Join(dir, manifestFileName(fileNum))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{
+		dir:     dir,
+		fileNum: fileNum,
+		f:       f,
+		writer:  bufio.NewWriter(f),
+	}
+
+	err = m.append(snapshot)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = setCurrentManifest(dir, fileNum)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *manifest) append(e *versionEdit) error {
+	m.buf = appendRecordHeader(m.buf[:0])
+	m.buf = e.encode(m.buf)
+	sealRecord(m.buf)
+
+	_, err := m.writer.Write(m.buf)
+	if err != nil {
+		return err
+	}
+
+	err = m.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	m.size += int64(len(m.buf))
+
+	return m.f.Sync()
+}
+
+func (m *manifest) close() error {
+	err := m.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return m.f.Close()
+}
+
+func setCurrentManifest(dir string, fileNum uint64) error {
+	tmpPath := filepath.Join(dir, currentFileName+".tmp")
+
+	err := os.WriteFile(tmpPath, []byte(manifestFileName(fileNum)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(dir, currentFileName))
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func manifestFileName(fileNum uint64) string {
+	return fmt.Sprintf("%s%06d", manifestPrefix, fileNum)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tableFileNums(s *Storage) [][]uint64 {
+	s.tablesMutex.RLock()
+	defer s.tablesMutex.RUnlock()
+
+	var nums [][]uint64
+	for _, tables := range s.levels {
+		var level []uint64
+		for _, t := range tables {
+			level = append(level, t.fileNum)
+		}
+		nums = append(nums, level)
+	}
+
+	return nums
+}
+
+func TestManifestRecovery(t *testing.T) {
+	opts := Options{DataDir: t.TempDir()}
+	s := newTestStorage(t, opts)
+
+	for _, key := range []string{"a", "b", "c"} {
+		err := s.Set(key, []byte(key), 0)
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+	}
+
+	levels := tableFileNums(s)
+	lastSequence := s.lastSequence
+
+	closeTestStorage(t, s)
+
+	// A table of an interrupted flush and an edit torn by a crash behind
+	// zero-filled space.
+	leftover := tableFileName(opts.DataDir, 999)
+	err := os.WriteFile(leftover, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	current, err := os.ReadFile(filepath.Join(opts.DataDir, currentFileName))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(opts.DataDir, strings.TrimSpace(string(current))), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, err = f.Write(append(make([]byte, 512), 1, 2, 3))
+	f.Close()
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	s = newTestStorage(t, opts)
+
+	if got := tableFileNums(s); !reflect.DeepEqual(got, levels) {
+		t.Fatalf("recovered tables %v, want %v", got, levels)
+	}
+
+	if s.lastSequence != lastSequence {
+		t.Fatalf("recovered sequence %d, want %d", s.lastSequence, lastSequence)
+	}
+
+	_, err = os.Stat(leftover)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("leftover table was not removed: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if value, found := mustGet(t, s, key); !found || value != key {
+			t.Fatalf("Get(%q) = %q, %v", key, value, found)
+		}
+	}
+}
+
+func TestLegacyTableMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	// Tables named "<shard>.<timestamp>.sst" and, with leveled compaction,
+	// "<shard>.<timestamp>.<level>.sst", ordered by their timestamps.
+	for name, entries := range map[string][]decodedEntry{
+		"0.100.sst":   {{key: []byte("a"), value: []byte("old"), kind: entryKindPut}, {key: []byte("b"), value: []byte("b"), kind: entryKindPut}},
+		"1.200.sst":   {{key: []byte("a"), value: []byte("new"), kind: entryKindPut}, {key: []byte("b"), kind: entryKindDelete}},
+		"0.300.1.sst": {{key: []byte("c"), value: []byte("c"), kind: entryKindPut}},
+	} {
+		err := os.WriteFile(filepath.Join(dir, name), encodeTestTable(legacyTableFormatVersion, entries, 16), 0644)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		s := newTestStorage(t, Options{DataDir: dir})
+
+		if levels := tableFileNums(s); len(levels) < 2 || len(levels[0]) != 2 || len(levels[1]) != 1 {
+			t.Fatalf("open %d: got tables %v, want two in L0 and one in L1", i, levels)
+		}
+
+		for key, want := range map[string]string{"a": "new", "c": "c"} {
+			if value, found := mustGet(t, s, key); !found || value != want {
+				t.Fatalf("open %d: Get(%q) = %q, %v, want %q", i, key, value, found, want)
+			}
+		}
+
+		if value, found := mustGet(t, s, "b"); found {
+			t.Fatalf("open %d: Get(%q) = %q, want no value", i, "b", value)
+		}
+
+		for _, name := range []string{"0.100.sst", "1.200.sst", "0.300.1.sst"} {
+			_, err := os.Stat(filepath.Join(dir, name))
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("open %d: legacy table %s was kept: %v", i, name, err)
+			}
+		}
+
+		closeTestStorage(t, s)
+	}
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const DefaultMaxImmutableMemtables = 4
+
+// ErrFlushFailed is returned by every write after a background flush failed.
+// The immutable memtables cannot be drained anymore, so the error is final
+// and writes are not worth retrying.
+var ErrFlushFailed = errors.New("storage: background flush failed")
+
+// MemtableStats reports the memory of the memtables. Size counts the bytes of
+// the entries, which triggers flushes, MemoryUsage the arena chunks that back
+// them.
+type MemtableStats struct {
+	ActiveSize           int64
+	ActiveMemoryUsage    int64
+	ImmutableCount       int
+	ImmutableSize        int64
+	ImmutableMemoryUsage int64
+}
+
+// immutableMemtable is a frozen generation of the shard skiplists. It stays
+// readable until its tables are installed, walSegment is the first segment
+// with writes that came after it.
+type immutableMemtable struct {
+	skipLists       []*SkipList
+	rangeTombstones *rangeTombstoneList
+	size            int64
+	walSegment      uint64
+}
+
+func (s *Storage) maybeScheduleFlush() error {
+	if atomic.LoadInt64(&s.shardsSize) < s.maxMemSize {
+		return nil
+	}
+
+	return s.rotateMemtable(false)
+}
+
+// rotateMemtable swaps the shard skiplists for empty ones and hands the old
+// ones to the background flush. While too many immutable memtables wait for
+// their flush the active one keeps growing, the write controller stops writers
+// until the flush catches up.
+func (s *Storage) rotateMemtable(force bool) error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	if s.flushErr != nil {
+		return s.flushErr
+	}
+
+	// Range tombstones take no memtable bytes, a forced rotation flushes them
+	// on their own.
+	shardsSize := atomic.LoadInt64(&s.shardsSize)
+	if shardsSize <= 0 && !(force && s.rangeTombstones.Load().len() > 0) {
+		return nil
+	}
+
+	if !force && shardsSize < s.maxMemSize {
+		return nil
+	}
+
+	if !force && s.immutableCount() >= s.maxImmutables {
+		return nil
+	}
+
+	// With every shard locked no write is between its WAL record and its
+	// memtable insert, so the new segment holds exactly the later writes.
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+
+	unlock := func() {
+		for _, shard := range s.shards {
+			shard.mu.Unlock()
+		}
+	}
+
+	segmentID, err := s.wal.Rotate()
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	imm := &immutableMemtable{
+		skipLists:       make([]*SkipList, len(s.shards)),
+		rangeTombstones: s.rangeTombstones.Load(),
+		walSegment:      segmentID,
+	}
+
+	for i, shard := range s.shards {
+		imm.skipLists[i] = shard.memtable()
+		imm.size += imm.skipLists[i].Size()
+	}
+
+	// Readers do not lock the shards, the memtable has to be among the
+	// immutable ones before it is replaced so they cannot miss it.
+	s.immutablesMutex.Lock()
+	s.immutables = append(s.immutables, imm)
+	s.immutablesMutex.Unlock()
+
+	for _, shard := range s.shards {
+		shard.skipList.Store(NewSkipList())
+	}
+	s.rangeTombstones.Store(&rangeTombstoneList{})
+	atomic.AddInt64(&s.shardsSize, -imm.size)
+	unlock()
+
+	s.updateWriteStall()
+
+	select {
+	case s.flushCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// flush moves everything written so far into tables and waits for it.
+func (s *Storage) flush() error {
+	err := s.rotateMemtable(true)
+	if err != nil {
+		return err
+	}
+
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	for s.flushErr == nil && s.immutableCount() > 0 {
+		s.flushCond.Wait()
+	}
+
+	return s.flushErr
+}
+
+func (s *Storage) flushLoop() {
+	defer close(s.flushDone)
+
+	for range s.flushCh {
+		for {
+			s.immutablesMutex.RLock()
+			var imm *immutableMemtable
+			if len(s.immutables) > 0 {
+				imm = s.immutables[0]
+			}
+			s.immutablesMutex.RUnlock()
+
+			if imm == nil {
+				break
+			}
+
+			err := s.flushMemtable(imm)
+
+			s.flushMutex.Lock()
+			if err != nil {
+				log.Printf("Error during data flush %v", err)
+				s.flushErr = fmt.Errorf("%w: %w", ErrFlushFailed, err)
+				s.stall.fail(s.flushErr)
+			}
+			s.flushCond.Broadcast()
+			s.flushMutex.Unlock()
+
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+// flushMemtable writes a table per shard and installs them together with the
+// WAL position, the immutable memtable is dropped only afterwards so readers
+// always find its entries in one of the two. The range tombstones go into the
+// first table.
+func (s *Storage) flushMemtable(imm *immutableMemtable) error {
+	log.Println("Starting data flush...")
+
+	edit := &versionEdit{}
+	edit.setWALSegment(imm.walSegment)
+
+	rangeTombstones := imm.rangeTombstones.all()
+
+	var tables []*SSTable
+	for _, skipList := range imm.skipLists {
+		if skipList.Size() == 0 && len(rangeTombstones) == 0 {
+			continue
+		}
+
+		fileNum := s.newFileNumber()
+		table, err := createSSTable(tableFileName(s.dataDir, fileNum), s.tableOptions, skipList, rangeTombstones)
+		if err != nil {
+			return err
+		}
+		table.fileNum = fileNum
+		rangeTombstones = nil
+
+		edit.addTable(0, table.tableMeta)
+		tables = append(tables, table)
+	}
+
+	err := s.logAndApply(edit, tables)
+	if err != nil {
+		return err
+	}
+
+	s.immutablesMutex.Lock()
+	s.immutables = s.immutables[1:]
+	s.immutablesMutex.Unlock()
+	s.updateWriteStall()
+
+	err = s.wal.RemoveBefore(imm.walSegment)
+	if err != nil {
+		return err
+	}
+
+	s.compactor.schedule()
+
+	log.Println("Data flush is end")
+
+	return nil
+}
+
+func (s *Storage) immutableCount() int {
+	s.immutablesMutex.RLock()
+	defer s.immutablesMutex.RUnlock()
+
+	return len(s.immutables)
+}
+
+// getFromImmutables looks the key up in the immutable memtables from the
+// newest to the oldest.
+func (s *Storage) getFromImmutables(key string, shardIdx uint32, seq uint64) (decodedEntry, bool) {
+	s.immutablesMutex.RLock()
+	defer s.immutablesMutex.RUnlock()
+
+	for i := len(s.immutables) - 1; i >= 0; i-- {
+		e, found := s.immutables[i].skipLists[shardIdx].getEntry(key, seq)
+		if found {
+			return e, true
+		}
+	}
+
+	return decodedEntry{}, false
+}
+
+func (s *Storage) MemtableStats() MemtableStats {
+	var stats MemtableStats
+
+	for _, shard := range s.shards {
+		skipList := shard.memtable()
+		stats.ActiveSize += skipList.Size()
+		stats.ActiveMemoryUsage += skipList.MemoryUsage()
+	}
+
+	s.immutablesMutex.RLock()
+	stats.ImmutableCount = len(s.immutables)
+	for _, imm := range s.immutables {
+		stats.ImmutableSize += imm.size
+		for _, skipList := range imm.skipLists {
+			stats.ImmutableMemoryUsage += skipList.MemoryUsage()
+		}
+	}
+	s.immutablesMutex.RUnlock()
+
+	return stats
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFailedFlushFailsWrites(t *testing.T) {
+	s := newTestStorage(t, Options{
+		MaxImmutableMemtables: 1,
+		WriteStallTimeout:     time.Minute,
+	})
+
+	err := s.Set("a", []byte("1"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// Tables cannot be created in a missing directory.
+	s.dataDir = filepath.Join(s.dataDir, "missing")
+
+	err = s.flush()
+	if !errors.Is(err, ErrFlushFailed) {
+		t.Fatalf("flush: got %v, want %v", err, ErrFlushFailed)
+	}
+
+	startedAt := time.Now()
+	err = s.Set("b", []byte("2"), 0)
+	if !errors.Is(err, ErrFlushFailed) {
+		t.Fatalf("Set: got %v, want %v", err, ErrFlushFailed)
+	}
+
+	if time.Since(startedAt) > 10*time.Second {
+		t.Fatal("the write waited for the stall timeout")
+	}
+
+	value, found := mustGet(t, s, "a")
+	if !found || value != "1" {
+		t.Fatalf("Get = %q, %v, want the unflushed value", value, found)
+	}
+}
+
+func TestSkipListIteratorSkipsNewerVersions(t *testing.T) {
+	sl := NewSkipList()
+	for i, v := range []struct {
+		key string
+		seq uint64
+	}{{"a", 1}, {"b", 5}, {"b", 2}, {"c", 6}, {"d", 3}, {"d", 7}, {"e", 4}} {
+		sl.Set(v.key, []byte(fmt.Sprint(i)), 0, false, v.seq, 0)
+	}
+
+	it := sl.newIterator(4)
+
+	// Versions written after the iterator was created are never seen.
+	sl.Set("c", nil, 0, false, 8, 0)
+	sl.Set("bb", nil, 0, false, 9, 0)
+
+	versions := func(step func()) []string {
+		var got []string
+		for ; it.Valid(); step() {
+			got = append(got, fmt.Sprintf("%s@%d", it.Entry().key, it.Entry().seq))
+		}
+
+		return got
+	}
+
+	want := []string{"a@1", "b@2", "d@3", "e@4"}
+
+	it.SeekGE([]byte(""))
+	if got := versions(it.Next); !slices.Equal(got, want) {
+		t.Fatalf("forward got %v, want %v", got, want)
+	}
+
+	it.SeekToLast()
+	got := versions(it.Prev)
+	slices.Reverse(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("backward got %v reversed, want %v", got, want)
+	}
+
+	it.SeekGE([]byte("c"))
+	if !it.Valid() || string(it.Entry().key) != "d" {
+		t.Fatalf("SeekGE(c) stopped at %q", it.Entry().key)
+	}
+
+	it.Prev()
+	if !it.Valid() || string(it.Entry().key) != "b" || it.Entry().seq != 2 {
+		t.Fatalf("Prev after SeekGE(c) stopped at %s@%d", it.Entry().key, it.Entry().seq)
+	}
+
+	it.SeekLT([]byte("b"))
+	if !it.Valid() || string(it.Entry().key) != "a" {
+		t.Fatalf("SeekLT(b) stopped at %q", it.Entry().key)
+	}
+
+	it.Prev()
+	if it.Valid() {
+		t.Fatalf("Prev past the first version stopped at %q", it.Entry().key)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrNoMergeOperator  = errors.New("storage: merge requires a merge operator")
+	ErrInvalidMergeUint = errors.New("storage: merge value is not a uint64")
+)
+
+// MergeOperator combines a merge operand with the current value of a key,
+// existing is nil when the key has no live value. Operands are stored as they
+// are written and folded on reads and during compaction, oldest first, so
+// Merge must give the same result whenever it is called. Neither existing nor
+// operand may be modified or retained.
+type MergeOperator interface {
+	Merge(key string, existing []byte, operand []byte) ([]byte, error)
+}
+
+type uint64AddOperator struct{}
+
+// NewUint64AddOperator adds operands to the value, both are 8 byte big endian
+// unsigned integers and a missing value counts as zero. Sums wrap around.
+func NewUint64AddOperator() MergeOperator {
+	return uint64AddOperator{}
+}
+
+func (uint64AddOperator) Merge(key string, existing []byte, operand []byte) ([]byte, error) {
+	if len(operand) != 8 || (existing != nil && len(existing) != 8) {
+		return nil, ErrInvalidMergeUint
+	}
+
+	var sum uint64
+	if existing != nil {
+		sum = binary.BigEndian.Uint64(existing)
+	}
+	sum += binary.BigEndian.Uint64(operand)
+
+	return binary.BigEndian.AppendUint64(nil, sum), nil
+}
+
+type appendOperator struct {
+	delimiter []byte
+}
+
+// NewAppendOperator appends operands to the value, separated by delimiter
+// when the value is not empty.
+func NewAppendOperator(delimiter []byte) MergeOperator {
+	return appendOperator{delimiter: delimiter}
+}
+
+func (o appendOperator) Merge(key string, existing []byte, operand []byte) ([]byte, error) {
+	value := make([]byte, 0, len(existing)+len(o.delimiter)+len(operand))
+	value = append(value, existing...)
+	if len(existing) > 0 {
+		value = append(value, o.delimiter...)
+	}
+
+	return append(value, operand...), nil
+}
+
+// Merge records an operand that the merge operator folds into the value of the
+// key when it is read, without reading the current value.
+func (s *Storage) Merge(key string, operand []byte) error {
+	if s.mergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+
+	return s.apply(walRecord{kind: walRecordMerge, key: key, value: operand})
+}
+
+// fullMerge applies the operands, newest first, to the existing value.
+func fullMerge(op MergeOperator, key string, existing []byte, operands [][]byte) ([]byte, error) {
+	if op == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	value := existing
+	for i := len(operands) - 1; i >= 0; i-- {
+		var err error
+		value, err = op.Merge(key, value, operands[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+// resolveVersions turns the visible versions of a key, newest first, into its
+// value. Merge operands are folded into the newest put below them, or into no
+// value when a tombstone or nothing comes first, and take its flags.
+func resolveVersions(op MergeOperator, key string, versions []decodedEntry) (decodedEntry, bool, error) {
+	var operands [][]byte
+	var base decodedEntry
+
+	for _, e := range versions {
+		if e.kind == entryKindMerge {
+			operands = append(operands, e.value)
+			continue
+		}
+
+		if operands == nil {
+			return e, e.kind == entryKindPut, nil
+		}
+
+		base = e
+		break
+	}
+
+	if operands == nil {
+		return decodedEntry{}, false, nil
+	}
+
+	var existing []byte
+	var flags uint32
+	if base.kind == entryKindPut {
+		existing, flags = base.value, base.flags
+	}
+
+	value, err := fullMerge(op, key, existing, operands)
+	if err != nil {
+		return decodedEntry{}, false, err
+	}
+
+	e := versions[0]
+	e.kind = entryKindPut
+	e.value = value
+	e.flags = flags
+
+	return e, true, nil
+}
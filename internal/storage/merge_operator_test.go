@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadsPreferOperandFoldedInTables(t *testing.T) {
+	s := newTestStorage(t, Options{MergeOperator: NewAppendOperator([]byte(","))})
+
+	err := s.Merge("k", []byte("x"))
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	// The memtable still holds the operand while its table is installed and
+	// compacted, which folded it onto a base that is gone now.
+	addTestTable(t, s, 0, decodedEntry{key: []byte("k"), value: []byte("a,x"), kind: entryKindPut, seq: s.commits.visible()})
+
+	if value, _ := mustGet(t, s, "k"); value != "a,x" {
+		t.Fatalf("Get got %q, want %q", value, "a,x")
+	}
+
+	it := s.NewIterator("", "")
+	defer it.Close()
+
+	if !it.SeekToFirst() || it.Key() != "k" || string(it.Value()) != "a,x" {
+		t.Fatalf("iterator got %q=%q, want %q", it.Key(), it.Value(), "a,x")
+	}
+}
+
+func TestCompactionFoldsOperandsPerSnapshot(t *testing.T) {
+	s := newTestStorage(t, Options{
+		MergeOperator:          NewUint64AddOperator(),
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	write := func(fn func() error) {
+		t.Helper()
+
+		err := fn()
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	number := func(v uint64) []byte {
+		return binary.BigEndian.AppendUint64(nil, v)
+	}
+
+	write(func() error { return s.Set("k", number(1), 0) })
+	snap := s.NewSnapshot()
+	write(func() error { return s.Merge("k", number(2)) })
+	write(func() error { return s.Merge("k", number(3)) })
+
+	compactTestStorage(t, s)
+
+	value, _, _, err := snap.Get("k")
+	if err != nil || len(value) != 8 || binary.BigEndian.Uint64(value) != 1 {
+		t.Fatalf("snapshot Get = %v, %v, want 1", value, err)
+	}
+
+	if value, _ := mustGet(t, s, "k"); value != string(number(6)) {
+		t.Fatalf("Get = %v, want 6", []byte(value))
+	}
+
+	// The operands are folded above the snapshot, its version stays below.
+	if n := tableVersions(t, s, "k"); n != 2 {
+		t.Fatalf("%d versions left, want 2", n)
+	}
+
+	snap.Release()
+	write(func() error { return s.Set("other", nil, 0) })
+	compactTestStorage(t, s)
+
+	if n := tableVersions(t, s, "k"); n != 1 {
+		t.Fatalf("%d versions left after the release, want 1", n)
+	}
+
+	if value, _ := mustGet(t, s, "k"); value != string(number(6)) {
+		t.Fatalf("Get after the release = %v, want 6", []byte(value))
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// internalIterator walks entries ordered by key and newer versions first.
+// SeekLT and SeekToLast position it for Prev, SeekGE for Next.
+type internalIterator interface {
+	SeekGE(key []byte)
+	SeekLT(key []byte)
+	SeekToLast()
+	Next()
+	Prev()
+	Valid() bool
+	Entry() *decodedEntry
+	Err() error
+}
+
+// mergingIterator merges the children into one ordered stream, after a
+// backward seek it walks the stream in reverse.
+type mergingIterator struct {
+	iters []internalIterator
+	heap  iteratorHeap
+}
+
+func newMergingIterator(iters []internalIterator) *mergingIterator {
+	return &mergingIterator{
+		iters: iters,
+		heap:  iteratorHeap{iters: make([]internalIterator, 0, len(iters))},
+	}
+}
+
+func (m *mergingIterator) SeekGE(key []byte) {
+	for _, it := range m.iters {
+		it.SeekGE(key)
+	}
+	m.init(false)
+}
+
+func (m *mergingIterator) SeekLT(key []byte) {
+	for _, it := range m.iters {
+		it.SeekLT(key)
+	}
+	m.init(true)
+}
+
+func (m *mergingIterator) SeekToLast() {
+	for _, it := range m.iters {
+		it.SeekToLast()
+	}
+	m.init(true)
+}
+
+func (m *mergingIterator) init(reverse bool) {
+	m.heap.reverse = reverse
+	m.heap.iters = m.heap.iters[:0]
+
+	for _, it := range m.iters {
+		if it.Valid() {
+			m.heap.iters = append(m.heap.iters, it)
+		}
+	}
+	heap.Init(&m.heap)
+}
+
+func (m *mergingIterator) Next() {
+	m.advance(internalIterator.Next)
+}
+
+func (m *mergingIterator) Prev() {
+	m.advance(internalIterator.Prev)
+}
+
+func (m *mergingIterator) advance(move func(internalIterator)) {
+	top := m.heap.iters[0]
+	move(top)
+
+	if top.Valid() {
+		heap.Fix(&m.heap, 0)
+		return
+	}
+
+	heap.Pop(&m.heap)
+}
+
+func (m *mergingIterator) Valid() bool {
+	return len(m.heap.iters) > 0
+}
+
+func (m *mergingIterator) Entry() *decodedEntry {
+	return m.heap.iters[0].Entry()
+}
+
+func (m *mergingIterator) Err() error {
+	for _, it := range m.iters {
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type iteratorHeap struct {
+	iters   []internalIterator
+	reverse bool
+}
+
+func (h iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h iteratorHeap) Less(i, j int) bool {
+	a, b := h.iters[i].Entry(), h.iters[j].Entry()
+
+	if res := bytes.Compare(a.key, b.key); res != 0 {
+		return (res < 0) != h.reverse
+	}
+
+	return (a.seq > b.seq) != h.reverse
+}
+
+func (h iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.iters = append(h.iters, x.(internalIterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	n := len(h.iters)
+	x := h.iters[n-1]
+	h.iters = h.iters[:n-1]
+
+	return x
+}
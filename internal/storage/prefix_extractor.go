@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PrefixExtractor maps keys to the prefixes tables build prefix filters over.
+// Prefix must depend only on the part of the key it returns, so that every key
+// starting with a returned prefix maps to that same prefix. Keys it reports
+// false for are not added to prefix filters.
+// Name is persisted in tables, filters built by a differently named extractor
+// are ignored.
+type PrefixExtractor interface {
+	Name() string
+	Prefix(key string) (string, bool)
+}
+
+type fixedPrefixExtractor struct {
+	length int
+}
+
+// NewFixedPrefixExtractor uses the first length bytes of a key as its prefix,
+// shorter keys have no prefix.
+func NewFixedPrefixExtractor(length int) PrefixExtractor {
+	return fixedPrefixExtractor{length: length}
+}
+
+func (e fixedPrefixExtractor) Name() string {
+	return fmt.Sprintf("fixed:%d", e.length)
+}
+
+func (e fixedPrefixExtractor) Prefix(key string) (string, bool) {
+	if len(key) < e.length {
+		return "", false
+	}
+
+	return key[:e.length], true
+}
+
+type delimiterPrefixExtractor struct {
+	delimiter string
+	count     int
+}
+
+// NewDelimiterPrefixExtractor uses a key up to and including its count-th
+// delimiter as its prefix, so with ":" and 2 the prefix of "user:123:name" is
+// "user:123:".
+func NewDelimiterPrefixExtractor(delimiter string, count int) PrefixExtractor {
+	return delimiterPrefixExtractor{delimiter: delimiter, count: count}
+}
+
+func (e delimiterPrefixExtractor) Name() string {
+	return fmt.Sprintf("delimiter:%q:%d", e.delimiter, e.count)
+}
+
+func (e delimiterPrefixExtractor) Prefix(key string) (string, bool) {
+	end := 0
+	for i := 0; i < e.count; i++ {
+		idx := strings.Index(key[end:], e.delimiter)
+		if idx < 0 {
+			return "", false
+		}
+		end += idx + len(e.delimiter)
+	}
+
+	return key[:end], true
+}
@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrInvalidRange = errors.New("storage: range start is after its end")
+
+// rangeTombstone deletes every version of the keys in [start, end) that is
+// older than the tombstone itself.
+type rangeTombstone struct {
+	start string
+	end   string
+	seq   uint64
+}
+
+func (t rangeTombstone) covers(key string) bool {
+	return t.start <= key && key < t.end
+}
+
+// overlaps tells whether the tombstone covers a key of the inclusive range.
+func (t rangeTombstone) overlaps(minKey string, maxKey string) bool {
+	return t.start <= maxKey && minKey < t.end
+}
+
+// rangeTombstoneList holds the range tombstones of a memtable generation, they
+// are flushed together with its skiplists.
+type rangeTombstoneList struct {
+	mu         sync.RWMutex
+	tombstones []rangeTombstone
+}
+
+func (l *rangeTombstoneList) add(t rangeTombstone) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tombstones = append(l.tombstones, t)
+}
+
+func (l *rangeTombstoneList) all() []rangeTombstone {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return append([]rangeTombstone(nil), l.tombstones...)
+}
+
+func (l *rangeTombstoneList) len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.tombstones)
+}
+
+// DeleteRange deletes every key in [start, end) with a single range tombstone,
+// the keys are not read. An empty range deletes nothing.
+func (s *Storage) DeleteRange(start string, end string) error {
+	if len(start) > MaxKeySize || len(end) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	if start > end {
+		return ErrInvalidRange
+	}
+
+	if start == end {
+		return nil
+	}
+
+	return s.apply(walRecord{kind: walRecordRangeDelete, key: start, value: []byte(end)})
+}
+
+// ClearRange deletes every key in [start, end) like DeleteRange and hands the
+// memtable to the background flush right away. Reads take a slower path while
+// a range tombstone is live, once it is in a table compaction drops the tables
+// it covers and retires it without waiting for the memtable to fill up.
+func (s *Storage) ClearRange(start string, end string) error {
+	err := s.DeleteRange(start, end)
+	if err != nil {
+		return err
+	}
+
+	return s.rotateMemtable(true)
+}
+
+// hasRangeTombstones tells whether reads have to look for range tombstones.
+// The memtables are checked first, a tombstone leaves them only after its
+// table is installed.
+func (s *Storage) hasRangeTombstones() bool {
+	if s.rangeTombstones.Load().len() > 0 {
+		return true
+	}
+
+	s.immutablesMutex.RLock()
+	for _, imm := range s.immutables {
+		if imm.rangeTombstones.len() > 0 {
+			s.immutablesMutex.RUnlock()
+			return true
+		}
+	}
+	s.immutablesMutex.RUnlock()
+
+	return atomic.LoadInt64(&s.rangeTombstoneTables) > 0
+}
+
+// coveringRangeTombstones returns the range tombstones visible at seq that
+// cover the key. The caller holds the tables lock.
+func (s *Storage) coveringRangeTombstones(key string, seq uint64) []rangeTombstone {
+	var covering []rangeTombstone
+	collect := func(tombstones []rangeTombstone) {
+		for _, t := range tombstones {
+			if t.seq <= seq && t.covers(key) {
+				covering = append(covering, t)
+			}
+		}
+	}
+
+	collect(s.rangeTombstones.Load().all())
+
+	s.immutablesMutex.RLock()
+	for _, imm := range s.immutables {
+		collect(imm.rangeTombstones.all())
+	}
+	s.immutablesMutex.RUnlock()
+
+	for level, tables := range s.levels {
+		if level == 0 {
+			for _, t := range tables {
+				if key >= t.minKey && key <= t.maxKey {
+					collect(t.rangeTombstones)
+				}
+			}
+			continue
+		}
+
+		if t := findTable(tables, key); t != nil {
+			collect(t.rangeTombstones)
+		}
+	}
+
+	return covering
+}
+
+// rangeTombstonesIn returns the range tombstones visible at seq that overlap
+// [lower, upper), an empty upper means no upper bound. The caller holds the
+// tables lock.
+func (s *Storage) rangeTombstonesIn(lower string, upper string, seq uint64) []rangeTombstone {
+	var found []rangeTombstone
+	collect := func(tombstones []rangeTombstone) {
+		for _, t := range tombstones {
+			if t.seq <= seq && t.end > lower && (upper == "" || t.start < upper) {
+				found = append(found, t)
+			}
+		}
+	}
+
+	collect(s.rangeTombstones.Load().all())
+
+	s.immutablesMutex.RLock()
+	for _, imm := range s.immutables {
+		collect(imm.rangeTombstones.all())
+	}
+	s.immutablesMutex.RUnlock()
+
+	for _, tables := range s.levels {
+		for _, t := range tables {
+			collect(t.rangeTombstones)
+		}
+	}
+
+	return found
+}
+
+// withRangeTombstones returns the versions of a key, newest first, with a range
+// deletion entry for every covering tombstone that is newer than one of them.
+func withRangeTombstones(versions []decodedEntry, tombstones []rangeTombstone) []decodedEntry {
+	if len(versions) == 0 || len(tombstones) == 0 {
+		return versions
+	}
+
+	key := string(versions[0].key)
+	oldest := versions[len(versions)-1].seq
+
+	var seqs []uint64
+	for _, t := range tombstones {
+		if t.seq > oldest && t.covers(key) {
+			seqs = append(seqs, t.seq)
+		}
+	}
+
+	if len(seqs) == 0 {
+		return versions
+	}
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] > seqs[j]
+	})
+
+	merged := make([]decodedEntry, 0, len(versions)+len(seqs))
+	i := 0
+	for _, e := range versions {
+		for ; i < len(seqs) && seqs[i] > e.seq; i++ {
+			if i > 0 && seqs[i] == seqs[i-1] {
+				continue
+			}
+			merged = append(merged, decodedEntry{key: e.key, kind: entryKindRangeDelete, seq: seqs[i]})
+		}
+		merged = append(merged, e)
+	}
+
+	return merged
+}
+
+// countRangeTombstoneTables counts the live tables with range tombstones, reads
+// skip looking for them while there are none. The caller holds the tables
+// lock for writing.
+func (s *Storage) countRangeTombstoneTables() {
+	var count int64
+	for _, tables := range s.levels {
+		for _, t := range tables {
+			if len(t.rangeTombstones) > 0 {
+				count++
+			}
+		}
+	}
+
+	atomic.StoreInt64(&s.rangeTombstoneTables, count)
+}
+
+// compactionRangeTombstones returns the range tombstones of the live tables
+// that overlap the compacted tables, and the ones of the compacted tables that
+// are kept with the number of dropped ones. A range tombstone is dropped once
+// every reader sees it and no other table can hold a version it deletes.
+func (s *Storage) compactionRangeTombstones(tables []*SSTable, snapshots []uint64) ([]rangeTombstone, []rangeTombstone, int64) {
+	s.tablesMutex.RLock()
+	defer s.tablesMutex.RUnlock()
+
+	compacted := make(map[*SSTable]bool, len(tables))
+	for _, t := range tables {
+		compacted[t] = true
+	}
+	minKey, maxKey := keyRange(tables)
+
+	var covering []rangeTombstone
+	for _, level := range s.levels {
+		for _, t := range level {
+			for _, rt := range t.rangeTombstones {
+				if rt.overlaps(minKey, maxKey) {
+					covering = append(covering, rt)
+				}
+			}
+		}
+	}
+
+	var kept []rangeTombstone
+	var dropped int64
+	seen := make(map[rangeTombstone]bool)
+	for _, t := range tables {
+		for _, rt := range t.rangeTombstones {
+			if seen[rt] {
+				continue
+			}
+			seen[rt] = true
+
+			if snapshotStripe(snapshots, rt.seq) == 0 && !s.hasOlderVersions(rt, compacted) {
+				dropped++
+				continue
+			}
+
+			kept = append(kept, rt)
+		}
+	}
+
+	return covering, kept, dropped
+}
+
+// hasOlderVersions tells whether a table outside the compaction may hold a
+// version in the range that is older than the tombstone. Memtables only hold
+// newer versions than the tables. The caller holds the tables lock.
+func (s *Storage) hasOlderVersions(rt rangeTombstone, compacted map[*SSTable]bool) bool {
+	for _, level := range s.levels {
+		for _, t := range level {
+			if !compacted[t] && t.smallestSeq < rt.seq && rt.overlaps(t.minKey, t.maxKey) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// obsoleteTables returns the tables whose whole range is deleted by a newer
+// range tombstone of another table that every reader sees. They are dropped
+// without being read. The caller holds the tables lock.
+func (s *Storage) obsoleteTables(snapshots []uint64) (*versionEdit, []*SSTable) {
+	var tombstones []rangeTombstone
+	for _, level := range s.levels {
+		for _, t := range level {
+			for _, rt := range t.rangeTombstones {
+				if snapshotStripe(snapshots, rt.seq) == 0 {
+					tombstones = append(tombstones, rt)
+				}
+			}
+		}
+	}
+
+	if len(tombstones) == 0 {
+		return nil, nil
+	}
+
+	edit := &versionEdit{}
+	var obsolete []*SSTable
+	for level, tables := range s.levels {
+		for _, t := range tables {
+			for _, rt := range tombstones {
+				if rt.seq > t.largestSeq && rt.start <= t.minKey && rt.end > t.maxKey {
+					edit.deleteTable(level, t.fileNum)
+					obsolete = append(obsolete, t)
+					break
+				}
+			}
+		}
+	}
+
+	return edit, obsolete
+}
+
+// rangeTombstoneCompaction picks a table holding a range tombstone that the
+// compaction of the table alone can drop, so reads stop looking for it. The
+// caller holds the tables lock.
+func (s *Storage) rangeTombstoneCompaction(snapshots []uint64) *compaction {
+	for level, tables := range s.levels {
+		for _, t := range tables {
+			compacted := map[*SSTable]bool{t: true}
+
+			for _, rt := range t.rangeTombstones {
+				if snapshotStripe(snapshots, rt.seq) != 0 || s.hasOlderVersions(rt, compacted) {
+					continue
+				}
+
+				comp := &compaction{
+					level:       level,
+					outputLevel: level,
+					inputs:      []*SSTable{t},
+				}
+
+				for _, other := range s.levels {
+					for _, o := range overlappingTables(other, t.minKey, t.maxKey) {
+						if o != t {
+							comp.outside = append(comp.outside, o)
+						}
+					}
+				}
+
+				return comp
+			}
+		}
+	}
+
+	return nil
+}
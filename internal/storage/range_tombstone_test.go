@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteRangeRejectsLargeKeys(t *testing.T) {
+	s := newTestStorage(t, Options{})
+	large := strings.Repeat("k", MaxKeySize+1)
+
+	err := s.DeleteRange(large, large+"z")
+	if !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("large start: got %v, want %v", err, ErrKeyTooLarge)
+	}
+
+	err = s.DeleteRange("a", large)
+	if !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("large end: got %v, want %v", err, ErrKeyTooLarge)
+	}
+
+	err = s.DeleteRange("b", "a")
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("reversed range: got %v, want %v", err, ErrInvalidRange)
+	}
+}
+
+func TestClearRangeRetiresTombstone(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			err := s.Set(fmt.Sprintf("k%d-%03d", i, j), []byte("v"), 0)
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+		}
+
+		err := s.flush()
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+
+	err := s.ClearRange("", "\xff")
+	if err != nil {
+		t.Fatalf("ClearRange: %v", err)
+	}
+
+	err = s.Set("after", []byte("v"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for s.hasRangeTombstones() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the range tombstone is still live: %+v", s.CompactionStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := s.CompactionStats()
+	if stats.TablesDropped == 0 || stats.RangeTombstonesDropped != 1 {
+		t.Fatalf("got %d tables and %d range tombstones dropped", stats.TablesDropped, stats.RangeTombstonesDropped)
+	}
+
+	if _, found := mustGet(t, s, "k1-050"); found {
+		t.Fatal("a cleared key is still found")
+	}
+
+	if value, found := mustGet(t, s, "after"); !found || value != "v" {
+		t.Fatalf("Get(after) = %q, %v", value, found)
+	}
+}
+
+func TestCompactionFoldsRangeTombstone(t *testing.T) {
+	s := newTestStorage(t, Options{
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	write := func(fn func() error) {
+		t.Helper()
+
+		err := fn()
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	write(func() error {
+		for _, key := range []string{"a", "c", "d", "e", "g"} {
+			err := s.Set(key, []byte("old"), 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	snap := s.NewSnapshot()
+	write(func() error { return s.DeleteRange("c", "f") })
+	write(func() error { return s.Set("d", []byte("new"), 0) })
+
+	compactTestStorage(t, s)
+
+	check := func(get func(key string) (string, bool), want map[string]string) {
+		t.Helper()
+
+		for _, key := range []string{"a", "c", "d", "e", "g"} {
+			value, found := get(key)
+			if wantValue, ok := want[key]; found != ok || value != wantValue {
+				t.Fatalf("Get(%q) = %q, %v, want %q, %v", key, value, found, wantValue, ok)
+			}
+		}
+	}
+
+	latest := map[string]string{"a": "old", "d": "new", "g": "old"}
+	check(func(key string) (string, bool) { return mustGet(t, s, key) }, latest)
+
+	// The snapshot still sees the versions the tombstone covers.
+	check(func(key string) (string, bool) {
+		value, _, found, err := snap.Get(key)
+		if err != nil {
+			t.Fatalf("snapshot Get: %v", err)
+		}
+
+		return string(value), found
+	}, map[string]string{"a": "old", "c": "old", "d": "old", "e": "old", "g": "old"})
+
+	snap.Release()
+	write(func() error { return s.Set("z", nil, 0) })
+	compactTestStorage(t, s)
+
+	check(func(key string) (string, bool) { return mustGet(t, s, key) }, latest)
+
+	for key, want := range map[string]int{"c": 0, "d": 1, "e": 0} {
+		if n := tableVersions(t, s, key); n != want {
+			t.Fatalf("%d versions of %q left, want %d", n, key, want)
+		}
+	}
+
+	if s.hasRangeTombstones() || s.CompactionStats().RangeTombstonesDropped != 1 {
+		t.Fatalf("the range tombstone was not dropped: %+v", s.CompactionStats())
+	}
+
+	it := s.NewIterator("", "")
+	defer it.Close()
+
+	got := iteratorKeys(it, it.Next)
+	want := []string{"a=old", "d=new", "g=old", "z="}
+	if !slices.Equal(got, want) {
+		t.Fatalf("iterator got %v, want %v", got, want)
+	}
+}
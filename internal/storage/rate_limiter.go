@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	next        time.Time
+}
+
+func (l *rateLimiter) setRate(bytesPerSec int64) {
+	atomic.StoreInt64(&l.bytesPerSec, bytesPerSec)
+}
+
+func (l *rateLimiter) wait(n int) {
+	rate := atomic.LoadInt64(&l.bytesPerSec)
+	if rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	w.limiter.wait(len(p))
+
+	return w.w.Write(p)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const recordHeaderSize = 8
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errTornRecord = errors.New("torn record")
+
+// appendRecordHeader reserves space for a record header, the payload is
+// expected to be appended right after it and sealed with sealRecord.
+func appendRecordHeader(buf []byte) []byte {
+	return append(buf, make([]byte, recordHeaderSize)...)
+}
+
+func sealRecord(record []byte) {
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(record[0:4], crc32.Checksum(payload, crc32cTable))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(payload)))
+}
+
+// readRecords calls fn with the payload of every record and returns the size
+// of the complete records, a damaged or incomplete record ends the read with
+// errTornRecord. An empty record is never written, so a zero-filled tail left
+// by a crash is torn as well even though its checksum matches.
+func readRecords(f *os.File, fn func(payload []byte) error) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReaderSize(f, walBufferSize)
+	header := make([]byte, recordHeaderSize)
+	var payload []byte
+	var size int64
+
+	for {
+		_, err = io.ReadFull(reader, header)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return size, nil
+			}
+
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return size, errTornRecord
+			}
+
+			return size, err
+		}
+
+		checksum := binary.BigEndian.Uint32(header[0:4])
+		length := binary.BigEndian.Uint32(header[4:8])
+
+		// A garbage length must not allocate more than the file still holds.
+		if length == 0 || int64(length) > info.Size()-size-recordHeaderSize {
+			return size, errTornRecord
+		}
+
+		if cap(payload) < int(length) {
+			payload = make([]byte, length)
+		}
+		payload = payload[:length]
+
+		_, err = io.ReadFull(reader, payload)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return size, errTornRecord
+			}
+
+			return size, err
+		}
+
+		if crc32.Checksum(payload, crc32cTable) != checksum {
+			return size, errTornRecord
+		}
+
+		err = fn(payload)
+		if err != nil {
+			return size, err
+		}
+		size += recordHeaderSize + int64(length)
+	}
+}
@@ -2,32 +2,53 @@ package storage
 
 import (
 	"math/rand"
+	"sync/atomic"
 )
 
 const (
 	MaxLevel    = 32
 	Probability = 0.5
+
+	skipListBytesChunkSize = 256 * 1024
+	skipListWordsChunkSize = 32 * 1024
+
+	wordSize = 8
 )
 
-type Node struct {
-	key         string
-	value       []byte
-	flags       uint32
-	isTombstone bool
-	next        []*Node
-}
+// Nodes live in the word arena and are addressed by their reference, the head
+// by the reference 0, which no node has. A node is a run of words:
+//
+//	data ref, seq, key length << 32 | value length,
+//	flags << 32 | kind << 8 | height, expiration, next ref per level
+//
+// The key and the value are copied next to each other into the byte arena.
+const (
+	nodeData = iota
+	nodeSeq
+	nodeLengths
+	nodeMeta
+	nodeExpiresAt
+	nodeNext
+)
 
+// SkipList is safe for concurrent writers and readers without locks. Nodes
+// are linked bottom up with compare-and-swap and never removed, so a reader
+// always sees a consistent list and an insert that loses a race only has to
+// search again from its predecessor on that level.
 type SkipList struct {
-	head  *Node
-	level int
+	head  [MaxLevel]uint64
+	level int32
 	size  int64
+	bytes arena[byte]
+	words arena[uint64]
 }
 
 func NewSkipList() *SkipList {
-	return &SkipList{
-		head:  &Node{next: make([]*Node, MaxLevel)},
-		level: 1,
-	}
+	s := &SkipList{level: 1}
+	s.bytes.chunkSize = skipListBytesChunkSize
+	s.words.chunkSize = skipListWordsChunkSize
+
+	return s
 }
 
 func (s *SkipList) randomLevel() int {
@@ -39,66 +60,207 @@ func (s *SkipList) randomLevel() int {
 	return lvl
 }
 
-func (s *SkipList) Set(key string, value []byte, flags uint32, isTombstone bool) {
-	update := make([]*Node, MaxLevel)
-	current := s.head
+func (s *SkipList) nextAddr(ref uint64, level int) *uint64 {
+	if ref == 0 {
+		return &s.head[level]
+	}
+
+	return &s.words.get(ref)[nodeNext+level]
+}
+
+func (s *SkipList) next(ref uint64, level int) uint64 {
+	return atomic.LoadUint64(s.nextAddr(ref, level))
+}
+
+func (s *SkipList) entry(ref uint64) decodedEntry {
+	node := s.words.get(ref)
+	keyLen := node[nodeLengths] >> 32
+	end := keyLen + node[nodeLengths]&0xffffffff
+	data := s.bytes.get(node[nodeData])
+
+	e := decodedEntry{
+		key:       data[:keyLen:keyLen],
+		value:     data[keyLen:end:end],
+		flags:     uint32(node[nodeMeta] >> 32),
+		kind:      byte(node[nodeMeta] >> 8),
+		seq:       node[nodeSeq],
+		expiresAt: int64(node[nodeExpiresAt]),
+	}
+	e.expire()
 
-	for i := s.level - 1; i >= 0; i-- {
-		for current.next[i] != nil && current.next[i].key < key {
-			current = current.next[i]
+	return e
+}
+
+func (s *SkipList) key(ref uint64) []byte {
+	node := s.words.get(ref)
+
+	return s.bytes.get(node[nodeData])[:node[nodeLengths]>>32]
+}
+
+// less orders nodes by key and newer versions of the same key first.
+func (s *SkipList) less(ref uint64, key string, seq uint64) bool {
+	node := s.words.get(ref)
+	nodeKey := s.bytes.get(node[nodeData])[:node[nodeLengths]>>32]
+	if string(nodeKey) != key {
+		return string(nodeKey) < key
+	}
+
+	return node[nodeSeq] > seq
+}
+
+func (s *SkipList) isVersion(ref uint64, key string, seq uint64) bool {
+	return ref != 0 && s.words.get(ref)[nodeSeq] == seq && string(s.key(ref)) == key
+}
+
+// findSplice returns the last node before the version on the level and the
+// node after it, starting the search at prev.
+func (s *SkipList) findSplice(key string, seq uint64, level int, prev uint64) (uint64, uint64) {
+	for {
+		next := s.next(prev, level)
+		if next == 0 || !s.less(next, key, seq) {
+			return prev, next
 		}
-		update[i] = current
+		prev = next
 	}
+}
+
+func nodeSize(key string, value []byte, height int) int64 {
+	return int64(len(key)+len(value)) + int64(nodeNext+height)*wordSize
+}
 
-	target := current.next[0]
+func (s *SkipList) newNode(key string, value []byte, flags uint32, kind byte, seq uint64, expiresAt int64, height int) uint64 {
+	dataRef, data := s.bytes.alloc(len(key) + len(value))
+	copy(data, key)
+	copy(data[len(key):], value)
 
-	if target != nil && target.key == key {
-		target.value = value
-		target.flags = flags
-		target.isTombstone = isTombstone
-		return
+	ref, node := s.words.alloc(nodeNext + height)
+	node[nodeData] = dataRef
+	node[nodeSeq] = seq
+	node[nodeLengths] = uint64(len(key))<<32 | uint64(len(value))
+	node[nodeMeta] = uint64(flags)<<32 | uint64(kind)<<8 | uint64(height)
+	node[nodeExpiresAt] = uint64(expiresAt)
+
+	return ref
+}
+
+// Set inserts a version and returns the number of bytes it took: the key, the
+// value and the node words. Versions are never replaced, so overwriting a key
+// grows the memtable by the whole new entry. A version that is already present
+// is the same write applied again and is kept as is, a node allocated before
+// it was found is still counted. expiresAt is a unix time in seconds, 0 means
+// the version never expires.
+func (s *SkipList) Set(key string, value []byte, flags uint32, isTombstone bool, seq uint64, expiresAt int64) int64 {
+	kind := entryKindPut
+	if isTombstone {
+		kind = entryKindDelete
+	}
+
+	return s.set(key, value, flags, kind, seq, expiresAt)
+}
+
+// set inserts a version of any entry kind, merge operands included.
+func (s *SkipList) set(key string, value []byte, flags uint32, kind byte, seq uint64, expiresAt int64) int64 {
+	var prev, next [MaxLevel]uint64
+
+	level := int(atomic.LoadInt32(&s.level))
+	var current uint64
+	for i := level - 1; i >= 0; i-- {
+		prev[i], next[i] = s.findSplice(key, seq, i, current)
+		current = prev[i]
+	}
+
+	if s.isVersion(next[0], key, seq) {
+		return 0
 	}
 
-	newLevel := s.randomLevel()
-	if newLevel > s.level {
-		for i := s.level; i < newLevel; i++ {
-			update[i] = s.head
+	height := s.randomLevel()
+	for {
+		listLevel := atomic.LoadInt32(&s.level)
+		if int32(height) <= listLevel || atomic.CompareAndSwapInt32(&s.level, listLevel, int32(height)) {
+			break
 		}
-		s.level = newLevel
 	}
 
-	newNode := &Node{
-		key:         key,
-		value:       value,
-		flags:       flags,
-		isTombstone: isTombstone,
-		next:        make([]*Node, newLevel),
+	ref := s.newNode(key, value, flags, kind, seq, expiresAt, height)
+	size := nodeSize(key, value, height)
+	atomic.AddInt64(&s.size, size)
+
+	for i := 0; i < height; i++ {
+		if i >= level {
+			prev[i], next[i] = s.findSplice(key, seq, i, 0)
+		}
+
+		for {
+			atomic.StoreUint64(s.nextAddr(ref, i), next[i])
+			if atomic.CompareAndSwapUint64(s.nextAddr(prev[i], i), next[i], ref) {
+				break
+			}
+
+			prev[i], next[i] = s.findSplice(key, seq, i, prev[i])
+			if i == 0 && s.isVersion(next[0], key, seq) {
+				return size
+			}
+		}
 	}
 
-	for i := 0; i < newLevel; i++ {
-		newNode.next[i] = update[i].next[i]
-		update[i].next[i] = newNode
+	return size
+}
+
+// Get returns the newest version of the key visible at seq, an expired version
+// is returned as a tombstone. A merge operand is returned as the value, only
+// Storage folds operands. The value points into the memtable and must not be
+// modified.
+func (s *SkipList) Get(key string, seq uint64) ([]byte, uint32, bool, bool) {
+	e, found := s.getEntry(key, seq)
+	if !found {
+		return nil, 0, false, false
 	}
 
-	s.size += int64(len(key) + len(value) + 12)
+	return e.value, e.flags, e.kind == entryKindDelete, true
 }
 
-func (s *SkipList) Get(key string) ([]byte, uint32, bool, bool) {
-	current := s.head
-	for i := s.level - 1; i >= 0; i-- {
-		for current.next[i] != nil && current.next[i].key < key {
-			current = current.next[i]
-		}
+func (s *SkipList) getEntry(key string, seq uint64) (decodedEntry, bool) {
+	// The successor found on the lowest level is used as is, reading it
+	// again could return a newer version inserted in the meantime.
+	var current, target uint64
+	for i := int(atomic.LoadInt32(&s.level)) - 1; i >= 0; i-- {
+		current, target = s.findSplice(key, seq, i, current)
 	}
 
-	target := current.next[0]
-	if target != nil && target.key == key {
-		return target.value, target.flags, target.isTombstone, true
+	if target != 0 {
+		e := s.entry(target)
+		if string(e.key) == key {
+			return e, true
+		}
 	}
 
-	return nil, 0, false, false
+	return decodedEntry{}, false
+}
+
+func (s *SkipList) Delete(key string, seq uint64) int64 {
+	return s.Set(key, nil, 0, true, seq, 0)
 }
 
-func (s *SkipList) Delete(key string) {
-	s.Set(key, nil, 0, true)
+// Size returns the bytes taken by the entries of the memtable.
+func (s *SkipList) Size() int64 {
+	return atomic.LoadInt64(&s.size)
+}
+
+// MemoryUsage returns the bytes the memtable holds, including the unused
+// parts of its arena chunks.
+func (s *SkipList) MemoryUsage() int64 {
+	return s.bytes.capacity() + (s.words.capacity()+int64(len(s.head)))*wordSize
+}
+
+// forEach visits every version in order, keys and values point into the
+// memtable.
+func (s *SkipList) forEach(fn func(e decodedEntry) error) error {
+	for ref := s.next(0, 0); ref != 0; ref = s.next(ref, 0) {
+		err := fn(s.entry(ref))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
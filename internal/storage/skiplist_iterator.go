@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// skipListIterator walks the versions of a memtable visible at seq without
+// copying them. Writers keep inserting while it runs, their versions are newer
+// than seq and skipped, so it sees a stable list.
+type skipListIterator struct {
+	skipList *SkipList
+	seq      uint64
+	ref      uint64
+	entry    decodedEntry
+}
+
+func (s *SkipList) newIterator(seq uint64) *skipListIterator {
+	return &skipListIterator{skipList: s, seq: seq}
+}
+
+// before returns the last node ordered before the version, 0 when it is the
+// head.
+func (it *skipListIterator) before(key string, seq uint64) uint64 {
+	var current uint64
+	for i := int(atomic.LoadInt32(&it.skipList.level)) - 1; i >= 0; i-- {
+		current, _ = it.skipList.findSplice(key, seq, i, current)
+	}
+
+	return current
+}
+
+func (it *skipListIterator) SeekGE(key []byte) {
+	it.ref = it.skipList.next(it.before(string(key), math.MaxUint64), 0)
+	it.skipForward()
+}
+
+func (it *skipListIterator) SeekLT(key []byte) {
+	it.ref = it.before(string(key), math.MaxUint64)
+	it.skipBackward()
+}
+
+func (it *skipListIterator) SeekToLast() {
+	var current uint64
+	for i := int(atomic.LoadInt32(&it.skipList.level)) - 1; i >= 0; i-- {
+		for next := it.skipList.next(current, i); next != 0; next = it.skipList.next(current, i) {
+			current = next
+		}
+	}
+
+	it.ref = current
+	it.skipBackward()
+}
+
+func (it *skipListIterator) Next() {
+	it.ref = it.skipList.next(it.ref, 0)
+	it.skipForward()
+}
+
+// Prev searches the predecessor from the head, nodes only link forward.
+func (it *skipListIterator) Prev() {
+	it.ref = it.before(string(it.entry.key), it.entry.seq)
+	it.skipBackward()
+}
+
+func (it *skipListIterator) skipForward() {
+	for ; it.ref != 0; it.ref = it.skipList.next(it.ref, 0) {
+		if it.load() {
+			return
+		}
+	}
+}
+
+func (it *skipListIterator) skipBackward() {
+	for it.ref != 0 && !it.load() {
+		it.ref = it.before(string(it.entry.key), it.entry.seq)
+	}
+}
+
+// load decodes the current node and tells whether it is visible.
+func (it *skipListIterator) load() bool {
+	it.entry = it.skipList.entry(it.ref)
+
+	return it.entry.seq <= it.seq
+}
+
+func (it *skipListIterator) Valid() bool {
+	return it.ref != 0
+}
+
+func (it *skipListIterator) Entry() *decodedEntry {
+	return &it.entry
+}
+
+func (it *skipListIterator) Err() error {
+	return nil
+}
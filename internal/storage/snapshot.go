@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a consistent point-in-time view of the storage, it sees only the
+// writes committed before it was taken. Versions it can see are kept by
+// compaction until it is released.
+type Snapshot struct {
+	storage *Storage
+	seq     uint64
+	elem    *list.Element
+}
+
+func (s *Storage) NewSnapshot() *Snapshot {
+	s.snapshotsMutex.Lock()
+	defer s.snapshotsMutex.Unlock()
+
+	sn := &Snapshot{
+		storage: s,
+		seq:     s.commits.visible(),
+	}
+	sn.elem = s.snapshots.PushBack(sn)
+
+	return sn
+}
+
+// Get returns the value of the key as of the snapshot, read-only like the
+// value of Storage.Get.
+func (sn *Snapshot) Get(key string) ([]byte, uint32, bool, error) {
+	return sn.storage.get(key, sn.seq)
+}
+
+func (sn *Snapshot) Release() {
+	s := sn.storage
+
+	s.snapshotsMutex.Lock()
+	defer s.snapshotsMutex.Unlock()
+
+	if sn.elem != nil {
+		s.snapshots.Remove(sn.elem)
+		sn.elem = nil
+	}
+}
+
+// liveSnapshots returns the ascending sequence numbers compaction has to
+// preserve versions for. The visible sequence is included as well, any
+// snapshot taken later is at or above it.
+func (s *Storage) liveSnapshots() []uint64 {
+	s.snapshotsMutex.Lock()
+	defer s.snapshotsMutex.Unlock()
+
+	seqs := make([]uint64, 0, s.snapshots.Len()+1)
+	for e := s.snapshots.Front(); e != nil; e = e.Next() {
+		seqs = append(seqs, e.Value.(*Snapshot).seq)
+	}
+	seqs = append(seqs, s.commits.visible())
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	return seqs
+}
+
+// snapshotStripe returns the index of the oldest snapshot that sees seq, or
+// len(snapshots) when only the latest state does. Two versions of a key in the
+// same stripe are indistinguishable to every reader, so only the newer is kept.
+func snapshotStripe(snapshots []uint64, seq uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i] >= seq
+	})
+}
+
+// commitPipeline tracks writes that have a sequence number but are not yet in
+// the memtable. The visible sequence only advances over a contiguous prefix of
+// finished writes, so a snapshot never observes a write committed after it.
+type commitPipeline struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	visibleSeq uint64
+	finished   map[uint64]bool
+}
+
+func newCommitPipeline(seq uint64) *commitPipeline {
+	p := &commitPipeline{
+		visibleSeq: seq,
+		finished:   make(map[uint64]bool),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+func (p *commitPipeline) visible() uint64 {
+	return atomic.LoadUint64(&p.visibleSeq)
+}
+
+// finish publishes seq, it must be called for every assigned sequence number
+// whether the write succeeded or not and without holding a shard lock.
+func (p *commitPipeline) finish(seq uint64) {
+	p.finishRange(seq, seq)
+}
+
+// finishRange publishes [first, last] at once, so a batch becomes visible as
+// a whole. Sequence numbers are taken before the log is written, so a write
+// may finish ahead of an earlier one; it waits for the earlier writes to get
+// visible, otherwise a read right after it returned could still miss it.
+func (p *commitPipeline) finishRange(first uint64, last uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for seq := first; seq <= last; seq++ {
+		p.finished[seq] = true
+	}
+
+	next := p.visibleSeq
+	for p.finished[next+1] {
+		delete(p.finished, next+1)
+		next++
+	}
+
+	if next != p.visibleSeq {
+		atomic.StoreUint64(&p.visibleSeq, next)
+		p.cond.Broadcast()
+	}
+
+	for p.visibleSeq < last {
+		p.cond.Wait()
+	}
+}
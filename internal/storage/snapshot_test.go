@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// tableVersions counts the versions of the key in the tables.
+func tableVersions(t *testing.T, s *Storage, key string) int {
+	t.Helper()
+
+	s.tablesMutex.RLock()
+	defer s.tablesMutex.RUnlock()
+
+	n := 0
+	for _, tables := range s.levels {
+		for _, table := range tables {
+			it := table.newIterator()
+			for it.Next() {
+				if string(it.entry.key) == key {
+					n++
+				}
+			}
+
+			if it.Err() != nil {
+				t.Fatalf("table iterator: %v", it.Err())
+			}
+		}
+	}
+
+	return n
+}
+
+func TestSnapshotSurvivesCompaction(t *testing.T) {
+	s := newTestStorage(t, Options{
+		CompactionMinThreshold: 2,
+		CompactionMinTableSize: 1,
+	})
+
+	write := func(fn func() error) {
+		t.Helper()
+
+		err := fn()
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	write(func() error { return s.Set("k", []byte("v1"), 0) })
+	write(func() error { return s.Set("d", []byte("d"), 0) })
+
+	snap := s.NewSnapshot()
+	write(func() error { return s.Set("k", []byte("v2"), 0) })
+	write(func() error { return s.Delete("d") })
+	write(func() error { return s.Set("n", []byte("n"), 0) })
+
+	compactTestStorage(t, s)
+
+	for key, want := range map[string]string{"k": "v1", "d": "d"} {
+		value, _, found, err := snap.Get(key)
+		if err != nil || !found || string(value) != want {
+			t.Fatalf("snapshot Get(%q) = %q, %v, %v, want %q", key, value, found, err, want)
+		}
+	}
+
+	if _, _, found, _ := snap.Get("n"); found {
+		t.Fatal("snapshot sees a later write")
+	}
+
+	if value, _ := mustGet(t, s, "k"); value != "v2" {
+		t.Fatalf("Get(k) = %q, want %q", value, "v2")
+	}
+	if _, found := mustGet(t, s, "d"); found {
+		t.Fatal("Get(d) found a deleted key")
+	}
+
+	snap.Release()
+	write(func() error { return s.Set("x", []byte("x"), 0) })
+	compactTestStorage(t, s)
+
+	if n := tableVersions(t, s, "k"); n != 1 {
+		t.Fatalf("%d versions of k left after the release, want 1", n)
+	}
+	if n := tableVersions(t, s, "d"); n != 0 {
+		t.Fatalf("%d versions of d left after the release, want 0", n)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	const writers = 8
+	const writes = 3000
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Go(func() {
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+
+				err := s.Set(key, []byte(key), 0)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				value, _, found, err := s.Get(key)
+				if err != nil || !found || string(value) != key {
+					errs <- fmt.Errorf("Get(%q) right after Set = %q, %v, %v", key, value, found, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -2,21 +2,51 @@ package storage
 
 import (
 	"bufio"
-	"bytes"
+	"container/list"
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"os"
 	"sort"
+	"sync/atomic"
 )
 
 type SSTable struct {
-	f                      *os.File
-	writer                 *bufio.Writer
-	index                  []IndexEntry
-	indexStartOffset       int64
-	bloomFilterStartOffset int64
-	blockSize              int64
-	filter                 BloomFilter
+	tableMeta
+	f                   *os.File
+	path                string
+	writer              *bufio.Writer
+	index               []IndexEntry
+	formatVersion       uint32
+	dataEndOffset       int64
+	blockSize           int64
+	filter              BloomFilter
+	offset              int64
+	block               []byte
+	restarts            []uint32
+	restartCounter      int
+	compressor          blockCompressor
+	keyHashes           [][2]uint32
+	prefixExtractor     PrefixExtractor
+	prefixHashes        [][2]uint32
+	lastPrefix          string
+	prefixFilter        BloomFilter
+	prefixExtractorName string
+	rangeTombstones     []rangeTombstone
+	refs                int32
+	cache               *BlockCache
+	cacheID             uint64
+	files               *tableCache
+	fileRefs            int
+	lruElem             *list.Element
+}
+
+type TableOptions struct {
+	BlockSize       int64
+	PrefixExtractor PrefixExtractor
+	Compression     Compression
+	BlockCache      *BlockCache
+	files           *tableCache
 }
 
 type IndexEntry struct {
@@ -24,78 +54,172 @@ type IndexEntry struct {
 	Offset int64
 }
 
-func CreateSSTable(path string, blockSize int64, skipList *SkipList) error {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
+func CreateSSTable(path string, opts TableOptions, skipList *SkipList) (*SSTable, error) {
+	return createSSTable(path, opts, skipList, nil)
+}
 
-	table := &SSTable{
-		f:         f,
-		writer:    bufio.NewWriter(f),
-		blockSize: blockSize,
+// createSSTable writes the skiplist and the range tombstones of its memtable
+// into a new table.
+func createSSTable(path string, opts TableOptions, skipList *SkipList, rangeTombstones []rangeTombstone) (*SSTable, error) {
+	table, err := newSSTable(path, opts, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	err = table.Write(skipList)
+	err = table.write(skipList, rangeTombstones)
 	if err != nil {
 		closeError := table.Close()
 		if closeError != nil {
-			return err
+			return nil, err
 		}
 
-		return err
+		return nil, err
 	}
 
 	err = table.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return table.reopen()
 }
 
-func OpenSSTable(path string, blockSize int64) (*SSTable, error) {
-	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+func newSSTable(path string, opts TableOptions, limiter *rateLimiter) (*SSTable, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return nil, err
 	}
 
-	t := &SSTable{f: f, blockSize: blockSize}
+	var w io.Writer = f
+	if limiter != nil {
+		w = &throttledWriter{w: f, limiter: limiter}
+	}
 
-	err = t.readBloomFilter()
-	if err != nil {
-		closeError := f.Close()
-		if closeError != nil {
-			return nil, err
-		}
+	return &SSTable{
+		f:               f,
+		path:            path,
+		writer:          bufio.NewWriter(w),
+		formatVersion:   tableFormatVersion,
+		blockSize:       opts.BlockSize,
+		prefixExtractor: opts.PrefixExtractor,
+		compressor:      blockCompressor{compression: opts.Compression},
+		cache:           opts.BlockCache,
+		files:           opts.files,
+	}, nil
+}
 
+func OpenSSTable(path string, opts TableOptions) (*SSTable, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
 		return nil, err
 	}
 
-	err = t.readIndex()
+	t := &SSTable{
+		f:         f,
+		path:      path,
+		blockSize: opts.BlockSize,
+		refs:      1,
+		cache:     opts.BlockCache,
+		cacheID:   atomic.AddUint64(&nextTableCacheID, 1),
+		files:     opts.files,
+	}
+
+	if t.files != nil {
+		t.files.add(t)
+	}
+
+	err = t.open()
 	if err != nil {
-		closeError := f.Close()
+		closeError := t.Close()
 		if closeError != nil {
 			return nil, err
 		}
 
 		return nil, err
 	}
+	t.releaseFile()
 
 	return t, nil
 }
 
+func (t *SSTable) open() error {
+	info, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	t.size = info.Size()
+
+	err = t.readFooter()
+	if err != nil {
+		return err
+	}
+
+	return t.readKeyRange()
+}
+
 func (t *SSTable) Close() error {
-	return t.f.Close()
+	if t.files == nil || t.writer != nil {
+		return t.f.Close()
+	}
+
+	return t.files.close(t)
+}
+
+// ref pins the table for a reader that outlives the tables lock, the level
+// structure holds the first reference of every opened table.
+func (t *SSTable) ref() {
+	atomic.AddInt32(&t.refs, 1)
+}
+
+// unref drops a reference and deletes the table once it is no longer live and
+// nobody reads it.
+func (t *SSTable) unref() error {
+	if atomic.AddInt32(&t.refs, -1) > 0 {
+		return nil
+	}
+
+	err := t.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(t.path)
+}
+
+func (t *SSTable) reopen() (*SSTable, error) {
+	opened, err := OpenSSTable(t.path, TableOptions{BlockSize: t.blockSize, BlockCache: t.cache, files: t.files})
+	if err != nil {
+		return nil, err
+	}
+
+	opened.fileNum = t.fileNum
+	opened.smallestSeq = t.smallestSeq
+	opened.largestSeq = t.largestSeq
+
+	return opened, nil
+}
+
+func (t *SSTable) Get(searchKey string, seq uint64) ([]byte, uint32, bool, error) {
+	e, found, err := t.getEntry(searchKey, seq)
+	if err != nil || !found {
+		return nil, 0, false, err
+	}
+
+	val := make([]byte, len(e.value))
+	copy(val, e.value)
+
+	return val, e.flags, e.kind == entryKindDelete, nil
 }
 
-func (t *SSTable) Get(searchKey string) ([]byte, uint32, bool, error) {
+// getEntry finds the newest version of the key visible at seq, the value of
+// the entry points into the block.
+func (t *SSTable) getEntry(searchKey string, seq uint64) (decodedEntry, bool, error) {
 	if !t.filter.Contains([]byte(searchKey)) {
-		return nil, 0, false, nil
+		return decodedEntry{}, false, nil
 	}
 
 	if len(t.index) == 0 {
-		return nil, 0, false, nil
+		return decodedEntry{}, false, nil
 	}
 
 	i := sort.Search(len(t.index), func(i int) bool {
@@ -107,178 +231,301 @@ func (t *SSTable) Get(searchKey string) ([]byte, uint32, bool, error) {
 		targetIdx = i - 1
 	}
 
-	startOffset := t.index[targetIdx].Offset
+	b, err := t.getBlock(targetIdx, true)
+	if err != nil {
+		return decodedEntry{}, false, err
+	}
+
+	return t.seek(b, t.index[targetIdx].Offset, []byte(searchKey), seq)
+}
+
+// readBlock reads the data block, verifies its checksum and decompresses it.
+func (t *SSTable) readBlock(idx int) (*dataBlock, error) {
+	startOffset := t.index[idx].Offset
 	var endOffset int64
-	if targetIdx+1 < len(t.index) {
-		endOffset = t.index[targetIdx+1].Offset
+	if idx+1 < len(t.index) {
+		endOffset = t.index[idx+1].Offset
 	} else {
-		endOffset = t.bloomFilterStartOffset
+		endOffset = t.dataEndOffset
 	}
 
-	blockLen := endOffset - startOffset
-	blockBuf := make([]byte, blockLen)
-	_, err := t.f.ReadAt(blockBuf, startOffset)
+	f, err := t.acquireFile()
 	if err != nil {
-		return nil, 0, false, err
+		return nil, err
 	}
 
-	var pos int64 = 0
-	searchKeyBytes := []byte(searchKey)
-
-	for pos < blockLen {
-		kLen := binary.BigEndian.Uint16(blockBuf[pos : pos+2])
-		vLen := binary.BigEndian.Uint32(blockBuf[pos+2 : pos+6])
-		flags := binary.BigEndian.Uint32(blockBuf[pos+6 : pos+10])
-		isTombstone := binary.BigEndian.Uint16(blockBuf[pos+10:pos+12]) == 1
-		pos += 12
+	blockBuf := make([]byte, endOffset-startOffset)
+	_, err = f.ReadAt(blockBuf, startOffset)
+	t.releaseFile()
+	if err != nil {
+		return nil, err
+	}
 
-		key := blockBuf[pos : pos+int64(kLen)]
-		pos += int64(kLen)
+	if t.formatVersion == legacyTableFormatVersion {
+		return t.parseBlock(blockBuf, startOffset)
+	}
 
-		res := bytes.Compare(key, searchKeyBytes)
-		if res == 0 {
-			val := make([]byte, vLen)
-			copy(val, blockBuf[pos:pos+int64(vLen)])
-			return val, flags, isTombstone, nil
-		}
+	if len(blockBuf) < blockTrailerSize {
+		return nil, t.corruption(startOffset, "block is shorter than its trailer")
+	}
 
-		if res > 0 {
-			break
-		}
+	data := blockBuf[:len(blockBuf)-blockTrailerSize]
+	checksum := binary.BigEndian.Uint32(blockBuf[len(data):])
+	if crc32.Checksum(data, crc32cTable) != checksum {
+		return nil, t.corruption(startOffset, "block checksum mismatch")
+	}
 
-		pos += int64(vLen)
+	if t.formatVersion < compressedTableFormatVersion {
+		return t.parseBlock(data, startOffset)
 	}
 
-	return nil, 0, false, nil
-}
+	if len(data) < blockHeaderSize {
+		return nil, t.corruption(startOffset, "block is shorter than its header")
+	}
 
-func (t *SSTable) readBloomFilter() error {
-	_, err := t.f.Seek(-16, io.SeekEnd)
+	block, err := decompressBlock(Compression(data[0]), data[blockHeaderSize:])
 	if err != nil {
-		return err
+		return nil, t.corruption(startOffset, "block decompression: %v", err)
 	}
 
-	err = binary.Read(t.f, binary.BigEndian, &t.bloomFilterStartOffset)
-	if err != nil {
-		return err
+	return t.parseBlock(block, startOffset)
+}
+
+// getBlock returns the data block through the block cache if the table has
+// one, cached blocks are shared and must not be modified.
+func (t *SSTable) getBlock(idx int, fillCache bool) (*dataBlock, error) {
+	if t.cache == nil {
+		return t.readBlock(idx)
 	}
 
-	_, err = t.f.Seek(t.bloomFilterStartOffset, io.SeekStart)
-	if err != nil {
-		return err
+	key := blockCacheKey{tableID: t.cacheID, offset: t.index[idx].Offset}
+	b, ok := t.cache.get(key)
+	if ok {
+		return b, nil
 	}
 
-	var filterLen uint32
-	err = binary.Read(t.f, binary.BigEndian, &filterLen)
+	b, err := t.readBlock(idx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	filterBuf := make([]byte, filterLen)
-	_, err = io.ReadFull(t.f, filterBuf)
-	if err != nil {
-		return err
+	if fillCache {
+		t.cache.insert(key, b)
 	}
-	t.filter = BloomFilter(filterBuf)
 
-	return nil
+	return b, nil
 }
 
-func (t *SSTable) readIndex() error {
-	info, err := t.f.Stat()
+// readEntries decodes all entries of the block.
+func (t *SSTable) readEntries(idx int, fillCache bool) ([]decodedEntry, error) {
+	b, err := t.getBlock(idx, fillCache)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = t.f.Seek(-8, io.SeekEnd)
-	if err != nil {
-		return err
-	}
+	return t.decodeBlock(b, t.index[idx].Offset)
+}
 
-	err = binary.Read(t.f, binary.BigEndian, &t.indexStartOffset)
-	if err != nil {
-		return err
+// mayContainPrefix reports false only when the prefix filter proves that no
+// key of the table starts with prefix.
+func (t *SSTable) mayContainPrefix(extractor PrefixExtractor, prefix string) bool {
+	if extractor == nil || t.prefixExtractorName != extractor.Name() {
+		return true
 	}
 
-	t.index = make([]IndexEntry, 0, (info.Size()-t.indexStartOffset)/30)
-
-	_, err = t.f.Seek(t.indexStartOffset, io.SeekStart)
-	if err != nil {
-		return err
+	p, ok := extractor.Prefix(prefix)
+	if !ok {
+		return true
 	}
 
-	for {
-		curr, _ := t.f.Seek(0, io.SeekCurrent)
-		if curr >= info.Size()-16 {
-			break
-		}
+	return t.prefixFilter.Contains([]byte(p))
+}
 
-		var kLen uint16
-		err = binary.Read(t.f, binary.BigEndian, &kLen)
+// readKeyRange finds the smallest and the largest key of the table, the range
+// tombstones widen it to the ends of their ranges.
+func (t *SSTable) readKeyRange() error {
+	if len(t.index) > 0 {
+		t.minKey = t.index[0].Key
+
+		entries, err := t.readEntries(len(t.index)-1, false)
 		if err != nil {
 			return err
 		}
 
-		keyBuf := make([]byte, kLen)
-		_, err = io.ReadFull(t.f, keyBuf)
-		if err != nil {
-			return err
+		if len(entries) == 0 {
+			return t.corruption(t.index[len(t.index)-1].Offset, "last block is empty")
 		}
+		t.maxKey = string(entries[len(entries)-1].key)
+	}
 
-		var offset int64
-		err = binary.Read(t.f, binary.BigEndian, &offset)
-		if err != nil {
-			return err
+	for i, rt := range t.rangeTombstones {
+		if i == 0 && len(t.index) == 0 {
+			t.minKey, t.maxKey = rt.start, rt.end
 		}
 
-		t.index = append(t.index, IndexEntry{Key: string(keyBuf), Offset: offset})
+		t.minKey = min(t.minKey, rt.start)
+		t.maxKey = max(t.maxKey, rt.end)
 	}
 
 	return nil
 }
 
 func (t *SSTable) Write(skipList *SkipList) error {
-	var lastIndexEntryOffset int64 = 0
+	return t.write(skipList, nil)
+}
 
-	t.index = make([]IndexEntry, 0, skipList.size/t.blockSize)
-	t.filter = NewBloomFilter(int(skipList.size/64), 0.01)
+func (t *SSTable) write(skipList *SkipList, rangeTombstones []rangeTombstone) error {
+	t.index = make([]IndexEntry, 0, skipList.Size()/max(t.blockSize, 1))
 
-	curr := skipList.head.next[0]
-	var offset int64
-	for curr != nil {
-		if offset == 0 || (offset-lastIndexEntryOffset) >= t.blockSize {
-			t.index = append(t.index, IndexEntry{
-				Key:    curr.key,
-				Offset: offset,
-			})
+	err := skipList.forEach(func(e decodedEntry) error {
+		return t.add(string(e.key), e.value, e.flags, e.kind, e.seq, e.expiresAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rt := range rangeTombstones {
+		t.addRangeTombstone(rt)
+	}
 
-			lastIndexEntryOffset = offset
+	return t.Finish()
+}
+
+// Add appends an entry, entries must come in key order with newer versions of
+// a key first. All versions of a key are kept in the same block.
+func (t *SSTable) Add(key string, value []byte, flags uint32, isTombstone bool, seq uint64, expiresAt int64) error {
+	kind := entryKindPut
+	if isTombstone {
+		kind = entryKindDelete
+	}
+
+	return t.add(key, value, flags, kind, seq, expiresAt)
+}
+
+func (t *SSTable) add(key string, value []byte, flags uint32, kind byte, seq uint64, expiresAt int64) error {
+	first := len(t.index) == 0
+	if first {
+		t.minKey = key
+		t.smallestSeq = seq
+		t.largestSeq = seq
+	}
+
+	prevKey := t.maxKey
+	newKey := first || key != prevKey
+	t.maxKey = key
+	t.smallestSeq = min(t.smallestSeq, seq)
+	t.largestSeq = max(t.largestSeq, seq)
+
+	if first || (newKey && int64(len(t.block)) >= t.blockSize) {
+		if !first {
+			err := t.finishBlock()
+			if err != nil {
+				return err
+			}
 		}
 
-		size, err := t.writeEntry(curr.key, curr.value, curr.flags, curr.isTombstone)
+		t.index = append(t.index, IndexEntry{
+			Key:    key,
+			Offset: t.offset,
+		})
+	}
+
+	shared := 0
+	if len(t.block) == 0 || t.restartCounter >= blockRestartInterval {
+		t.restarts = append(t.restarts, uint32(len(t.block)))
+		t.restartCounter = 0
+	} else {
+		shared = sharedPrefixLen(prevKey, key)
+	}
+	t.restartCounter++
+
+	t.block = appendEntry(t.block, key, shared, value, flags, kind, seq, expiresAt)
+
+	if newKey {
+		t.keyHashes = append(t.keyHashes, hashKey([]byte(key)))
+		t.addPrefix(key)
+	}
+
+	return nil
+}
+
+// addRangeTombstone stores a range tombstone in the table, they are added after
+// the entries. The key range of the table grows to the end of the range, which
+// only the range tombstones section covers.
+func (t *SSTable) addRangeTombstone(rt rangeTombstone) {
+	if len(t.index) == 0 && len(t.rangeTombstones) == 0 {
+		t.minKey, t.maxKey = rt.start, rt.end
+		t.smallestSeq, t.largestSeq = rt.seq, rt.seq
+	}
+
+	t.minKey = min(t.minKey, rt.start)
+	t.maxKey = max(t.maxKey, rt.end)
+	t.smallestSeq = min(t.smallestSeq, rt.seq)
+	t.largestSeq = max(t.largestSeq, rt.seq)
+	t.rangeTombstones = append(t.rangeTombstones, rt)
+}
+
+func (t *SSTable) addPrefix(key string) {
+	if t.prefixExtractor == nil {
+		return
+	}
+
+	prefix, ok := t.prefixExtractor.Prefix(key)
+	if !ok || (len(t.prefixHashes) > 0 && prefix == t.lastPrefix) {
+		return
+	}
+
+	t.prefixHashes = append(t.prefixHashes, hashKey([]byte(prefix)))
+	t.lastPrefix = prefix
+}
+
+func (t *SSTable) Finish() error {
+	if len(t.index) > 0 {
+		err := t.finishBlock()
 		if err != nil {
 			return err
 		}
+	}
+	t.dataEndOffset = t.offset
 
-		offset += size
+	t.filter = NewBloomFilter(len(t.keyHashes), 0.01)
+	for _, h := range t.keyHashes {
+		t.filter.addHash(h)
+	}
+	t.keyHashes = nil
 
-		t.filter.Add([]byte(curr.key))
+	var footer tableFooter
+	var err error
 
-		curr = curr.next[0]
+	footer.filter, err = t.writeSection(t.filter)
+	if err != nil {
+		return err
+	}
+
+	if t.prefixExtractor != nil {
+		footer.prefixFilter, err = t.writeSection(t.encodePrefixFilter())
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(t.rangeTombstones) > 0 {
+		footer.rangeTombstones, err = t.writeSection(encodeRangeTombstones(t.rangeTombstones))
+		if err != nil {
+			return err
+		}
 	}
 
-	t.bloomFilterStartOffset = offset
-	err := t.writeBloomFilter()
+	footer.index, err = t.writeSection(encodeIndex(t.index))
 	if err != nil {
 		return err
 	}
 
-	filterSize := int64(4 + len([]byte(t.filter)))
-	t.indexStartOffset = t.bloomFilterStartOffset + filterSize
-	err = t.writeIndex()
+	_, err = t.writer.Write(footer.encode())
 	if err != nil {
 		return err
 	}
+	t.offset += tableFooterSize
 
 	err = t.writer.Flush()
 	if err != nil {
@@ -288,92 +535,89 @@ func (t *SSTable) Write(skipList *SkipList) error {
 	return t.f.Sync()
 }
 
-func (t *SSTable) writeEntry(key string, value []byte, flags uint32, isTombstone bool) (int64, error) {
-	var size int64
+func (t *SSTable) entries() int {
+	return len(t.keyHashes)
+}
 
-	err := binary.Write(t.writer, binary.BigEndian, uint16(len(key)))
-	if err != nil {
-		return 0, err
+// finishBlock appends the restart array to the buffered block, compresses it
+// and writes it with its compression header and checksum.
+func (t *SSTable) finishBlock() error {
+	for _, r := range t.restarts {
+		t.block = binary.BigEndian.AppendUint32(t.block, r)
 	}
+	t.block = binary.BigEndian.AppendUint32(t.block, uint32(len(t.restarts)))
 
-	err = binary.Write(t.writer, binary.BigEndian, uint32(len(value)))
+	compression, data, err := t.compressor.compress(t.block)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	err = binary.Write(t.writer, binary.BigEndian, flags)
-	if err != nil {
-		return 0, err
-	}
+	header := [blockHeaderSize]byte{byte(compression)}
+	checksum := crc32.Update(crc32.Checksum(header[:], crc32cTable), crc32cTable, data)
 
-	var isTombstoneVal uint16 = 0
-	if isTombstone {
-		isTombstoneVal = 1
-	}
-	err = binary.Write(t.writer, binary.BigEndian, isTombstoneVal)
+	_, err = t.writer.Write(header[:])
 	if err != nil {
-		return 0, err
+		return err
 	}
-	size += 12
 
-	var keySize int
-	keySize, err = t.writer.Write([]byte(key))
+	_, err = t.writer.Write(data)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	size += int64(keySize)
 
-	var valueSize int
-	valueSize, err = t.writer.Write(value)
+	err = binary.Write(t.writer, binary.BigEndian, checksum)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	size += int64(valueSize)
 
-	return size, nil
+	t.offset += int64(blockHeaderSize + len(data) + blockTrailerSize)
+	t.block = t.block[:0]
+	t.restarts = t.restarts[:0]
+	t.restartCounter = 0
+
+	return nil
 }
 
-func (t *SSTable) writeBloomFilter() error {
-	filterData := []byte(t.filter)
-	err := binary.Write(t.writer, binary.BigEndian, uint32(len(filterData)))
+func (t *SSTable) writeSection(data []byte) (sectionHandle, error) {
+	handle := sectionHandle{offset: t.offset, size: int64(len(data))}
+
+	_, err := t.writer.Write(data)
 	if err != nil {
-		return err
+		return handle, err
 	}
-	_, err = t.writer.Write(filterData)
+
+	err = binary.Write(t.writer, binary.BigEndian, crc32.Checksum(data, crc32cTable))
 	if err != nil {
-		return err
+		return handle, err
 	}
 
-	return nil
-}
+	t.offset += handle.size + blockTrailerSize
 
-func (t *SSTable) writeIndex() error {
-	for i := range t.index {
-		err := binary.Write(t.writer, binary.BigEndian, uint16(len(t.index[i].Key)))
-		if err != nil {
-			return err
-		}
-
-		_, err = t.writer.WriteString(t.index[i].Key)
-		if err != nil {
-			return err
-		}
+	return handle, nil
+}
 
-		err = binary.Write(t.writer, binary.BigEndian, t.index[i].Offset)
-		if err != nil {
-			return err
-		}
+func (t *SSTable) encodePrefixFilter() []byte {
+	t.prefixExtractorName = t.prefixExtractor.Name()
+	t.prefixFilter = NewBloomFilter(len(t.prefixHashes), 0.01)
+	for _, h := range t.prefixHashes {
+		t.prefixFilter.addHash(h)
 	}
+	t.prefixHashes = nil
 
-	err := binary.Write(t.writer, binary.BigEndian, t.bloomFilterStartOffset)
-	if err != nil {
-		return err
-	}
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(t.prefixExtractorName)))
+	buf = append(buf, t.prefixExtractorName...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(t.prefixFilter)))
+	buf = append(buf, t.prefixFilter...)
 
-	err = binary.Write(t.writer, binary.BigEndian, t.indexStartOffset)
-	if err != nil {
-		return err
+	return buf
+}
+
+func encodeIndex(index []IndexEntry) []byte {
+	var buf []byte
+	for i := range index {
+		buf = appendLengthPrefixed(buf, index[i].Key)
+		buf = binary.AppendUvarint(buf, uint64(index[i].Offset))
 	}
 
-	return nil
+	return buf
 }
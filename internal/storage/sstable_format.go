@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Table layout since format version 1:
+//
+//	data blocks   compression byte (since version 2), possibly compressed
+//	              entries with restart points (since version 3) and a crc32c
+//	              trailer each
+//	filter        bloom filter over keys, crc32c
+//	prefix filter optional bloom filter over key prefixes, crc32c
+//	range dels    optional range tombstones (since version 6), crc32c
+//	index         first key and offset of every block (uvarints since
+//	              version 4), crc32c
+//	footer        section handles, version, crc32c of the footer, magic
+//
+// The footer holds a handle for the range tombstones since version 6. Tables
+// without the magic are read as the legacy format 0: no checksums and a footer
+// of the filter and index offsets only.
+const (
+	legacyTableFormatVersion     uint32 = 0
+	checksumTableFormatVersion   uint32 = 1
+	compressedTableFormatVersion uint32 = 2
+	prefixTableFormatVersion     uint32 = 3
+	varintTableFormatVersion     uint32 = 4
+	ttlTableFormatVersion        uint32 = 5
+	rangeDelTableFormatVersion   uint32 = 6
+	tableFormatVersion                  = rangeDelTableFormatVersion
+
+	tableMagic           uint64 = 0x4c534d5353544231
+	tableFooterSize             = 4*16 + tableFooterTailSize
+	shortTableFooterSize        = 3*16 + tableFooterTailSize
+	tableFooterTailSize         = 4 + 4 + 8
+	blockHeaderSize             = 1
+	blockTrailerSize            = 4
+
+	legacyTableFooterSize = 16
+)
+
+var (
+	errEntryOutOfBounds = errors.New("entry is out of block bounds")
+	errRestartShared    = errors.New("restart entry shares a prefix")
+)
+
+type sectionHandle struct {
+	offset int64
+	size   int64
+}
+
+type tableFooter struct {
+	filter          sectionHandle
+	prefixFilter    sectionHandle
+	index           sectionHandle
+	rangeTombstones sectionHandle
+	version         uint32
+}
+
+func (f *tableFooter) encode() []byte {
+	buf := make([]byte, 0, tableFooterSize)
+	for _, h := range []sectionHandle{f.filter, f.prefixFilter, f.index, f.rangeTombstones} {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(h.offset))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(h.size))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, tableFormatVersion)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crc32cTable))
+	buf = binary.BigEndian.AppendUint64(buf, tableMagic)
+
+	return buf
+}
+
+// readFooter reads the version at the end of the footer first, it tells the
+// size of the footer.
+func (t *SSTable) readFooter() error {
+	if t.size >= shortTableFooterSize {
+		tail := make([]byte, tableFooterTailSize)
+		_, err := t.f.ReadAt(tail, t.size-tableFooterTailSize)
+		if err != nil {
+			return err
+		}
+
+		if binary.BigEndian.Uint64(tail[8:]) == tableMagic {
+			footerSize := int64(shortTableFooterSize)
+			if binary.BigEndian.Uint32(tail[0:4]) >= rangeDelTableFormatVersion {
+				footerSize = tableFooterSize
+			}
+
+			if t.size < footerSize {
+				return t.corruption(0, "table is too short")
+			}
+
+			buf := make([]byte, footerSize)
+			_, err = t.f.ReadAt(buf, t.size-footerSize)
+			if err != nil {
+				return err
+			}
+
+			return t.readSections(buf)
+		}
+	}
+
+	return t.readLegacySections()
+}
+
+func (t *SSTable) readSections(buf []byte) error {
+	footerOffset := t.size - int64(len(buf))
+	tail := len(buf) - tableFooterTailSize
+
+	checksum := binary.BigEndian.Uint32(buf[tail+4 : tail+8])
+	if crc32.Checksum(buf[:tail+4], crc32cTable) != checksum {
+		return t.corruption(footerOffset, "footer checksum mismatch")
+	}
+
+	var footer tableFooter
+	handles := []*sectionHandle{&footer.filter, &footer.prefixFilter, &footer.index, &footer.rangeTombstones}
+	for i, h := range handles[:tail/16] {
+		h.offset = int64(binary.BigEndian.Uint64(buf[i*16:]))
+		h.size = int64(binary.BigEndian.Uint64(buf[i*16+8:]))
+	}
+
+	footer.version = binary.BigEndian.Uint32(buf[tail : tail+4])
+	if footer.version < checksumTableFormatVersion || footer.version > tableFormatVersion {
+		return t.corruption(footerOffset, "unsupported format version %d", footer.version)
+	}
+	t.formatVersion = footer.version
+
+	filter, err := t.readSection(footer.filter, "filter", footerOffset)
+	if err != nil {
+		return err
+	}
+	t.filter = BloomFilter(filter)
+	t.dataEndOffset = footer.filter.offset
+
+	if footer.prefixFilter.size > 0 {
+		prefixFilter, err := t.readSection(footer.prefixFilter, "prefix filter", footerOffset)
+		if err != nil {
+			return err
+		}
+
+		err = t.parsePrefixFilter(prefixFilter, footer.prefixFilter.offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if footer.rangeTombstones.size > 0 {
+		rangeTombstones, err := t.readSection(footer.rangeTombstones, "range tombstones", footerOffset)
+		if err != nil {
+			return err
+		}
+
+		err = t.parseRangeTombstones(rangeTombstones, footer.rangeTombstones.offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	index, err := t.readSection(footer.index, "index", footerOffset)
+	if err != nil {
+		return err
+	}
+
+	return t.parseIndex(index, footer.index.offset)
+}
+
+func (t *SSTable) readSection(h sectionHandle, name string, limit int64) ([]byte, error) {
+	if h.offset < 0 || h.size < 0 || h.offset+h.size+blockTrailerSize > limit {
+		return nil, t.corruption(h.offset, "%s section is out of bounds", name)
+	}
+
+	buf := make([]byte, h.size+blockTrailerSize)
+	_, err := t.f.ReadAt(buf, h.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf[:h.size]
+	if crc32.Checksum(data, crc32cTable) != binary.BigEndian.Uint32(buf[h.size:]) {
+		return nil, t.corruption(h.offset, "%s checksum mismatch", name)
+	}
+
+	return data, nil
+}
+
+// readLegacySections reads tables written before checksums, the footer only
+// holds the offsets of the filter and the index and the optional prefix filter
+// section sits between them.
+func (t *SSTable) readLegacySections() error {
+	if t.size < legacyTableFooterSize {
+		return t.corruption(0, "table is too short")
+	}
+
+	footerOffset := t.size - legacyTableFooterSize
+	footer := make([]byte, legacyTableFooterSize)
+	_, err := t.f.ReadAt(footer, footerOffset)
+	if err != nil {
+		return err
+	}
+
+	filterOffset := int64(binary.BigEndian.Uint64(footer[0:8]))
+	indexOffset := int64(binary.BigEndian.Uint64(footer[8:16]))
+	if filterOffset < 0 || filterOffset+4 > indexOffset || indexOffset > footerOffset {
+		return t.corruption(footerOffset, "footer offsets are out of bounds")
+	}
+
+	meta := make([]byte, footerOffset-filterOffset)
+	_, err = t.f.ReadAt(meta, filterOffset)
+	if err != nil {
+		return err
+	}
+
+	indexStart := indexOffset - filterOffset
+	filterLen := int64(binary.BigEndian.Uint32(meta[0:4]))
+	if 4+filterLen > indexStart {
+		return t.corruption(filterOffset, "filter is out of bounds")
+	}
+
+	t.formatVersion = legacyTableFormatVersion
+	t.filter = BloomFilter(meta[4 : 4+filterLen])
+	t.dataEndOffset = filterOffset
+
+	if 4+filterLen < indexStart {
+		err = t.parsePrefixFilter(meta[4+filterLen:indexStart], filterOffset+4+filterLen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.parseIndex(meta[indexStart:], indexOffset)
+}
+
+func (t *SSTable) parsePrefixFilter(section []byte, offset int64) error {
+	if len(section) < 2 {
+		return t.corruption(offset, "prefix filter section is too short")
+	}
+	nameLen := int(binary.BigEndian.Uint16(section[0:2]))
+	pos := 2
+
+	if len(section) < pos+nameLen+4 {
+		return t.corruption(offset, "prefix extractor name is out of bounds")
+	}
+	t.prefixExtractorName = string(section[pos : pos+nameLen])
+	pos += nameLen
+
+	filterLen := int(binary.BigEndian.Uint32(section[pos : pos+4]))
+	pos += 4
+
+	if len(section) != pos+filterLen {
+		return t.corruption(offset, "prefix filter is out of bounds")
+	}
+	t.prefixFilter = BloomFilter(section[pos:])
+
+	return nil
+}
+
+// parseRangeTombstones decodes the range tombstones section, every tombstone is
+// its start and end key, each a uvarint length and the bytes, and the uvarint
+// sequence number.
+func (t *SSTable) parseRangeTombstones(buf []byte, offset int64) error {
+	d := entryDecoder{buf: buf}
+	for d.pos < int64(len(buf)) {
+		start := d.lengthPrefixed()
+		end := d.lengthPrefixed()
+		seq := d.uvarint()
+
+		if d.err != nil || start >= end {
+			return t.corruption(offset+d.pos, "range tombstone is out of bounds")
+		}
+
+		t.rangeTombstones = append(t.rangeTombstones, rangeTombstone{start: start, end: end, seq: seq})
+	}
+
+	return nil
+}
+
+func encodeRangeTombstones(tombstones []rangeTombstone) []byte {
+	var buf []byte
+	for _, rt := range tombstones {
+		buf = appendLengthPrefixed(buf, rt.start)
+		buf = appendLengthPrefixed(buf, rt.end)
+		buf = binary.AppendUvarint(buf, rt.seq)
+	}
+
+	return buf
+}
+
+// parseIndex decodes the index and checks that its blocks are ordered and lie
+// within the data section.
+func (t *SSTable) parseIndex(buf []byte, offset int64) error {
+	t.index = make([]IndexEntry, 0, len(buf)/30)
+
+	pos := 0
+	for pos < len(buf) {
+		var key string
+		var blockOffset int64
+		var err error
+		if t.formatVersion >= varintTableFormatVersion {
+			key, blockOffset, pos, err = decodeIndexEntry(buf, pos)
+		} else {
+			key, blockOffset, pos, err = decodeLegacyIndexEntry(buf, pos)
+		}
+
+		if err != nil {
+			return t.corruption(offset+int64(pos), "index entry is out of bounds")
+		}
+
+		prevOffset := int64(-1)
+		if len(t.index) > 0 {
+			prevOffset = t.index[len(t.index)-1].Offset
+		}
+
+		if blockOffset <= prevOffset || blockOffset >= t.dataEndOffset {
+			return t.corruption(offset+int64(pos), "index points outside of the data blocks")
+		}
+
+		t.index = append(t.index, IndexEntry{Key: key, Offset: blockOffset})
+	}
+
+	if len(t.index) > 0 && t.index[0].Offset != 0 {
+		return t.corruption(offset, "index does not start at the first block")
+	}
+
+	return nil
+}
+
+func decodeIndexEntry(buf []byte, pos int) (string, int64, int, error) {
+	d := entryDecoder{buf: buf, pos: int64(pos)}
+	kLen := d.uvarint()
+	if d.err != nil || kLen > uint64(int64(len(buf))-d.pos) {
+		return "", 0, pos, errEntryOutOfBounds
+	}
+
+	key := string(buf[d.pos : d.pos+int64(kLen)])
+	d.pos += int64(kLen)
+
+	blockOffset := d.uvarint()
+	if d.err != nil {
+		return "", 0, pos, d.err
+	}
+
+	return key, int64(blockOffset), int(d.pos), nil
+}
+
+func decodeLegacyIndexEntry(buf []byte, pos int) (string, int64, int, error) {
+	if len(buf) < pos+2 {
+		return "", 0, pos, errEntryOutOfBounds
+	}
+	kLen := int(binary.BigEndian.Uint16(buf[pos : pos+2]))
+	pos += 2
+
+	if len(buf) < pos+kLen+8 {
+		return "", 0, pos, errEntryOutOfBounds
+	}
+	key := string(buf[pos : pos+kLen])
+	pos += kLen
+
+	blockOffset := int64(binary.BigEndian.Uint64(buf[pos : pos+8]))
+	pos += 8
+
+	return key, blockOffset, pos, nil
+}
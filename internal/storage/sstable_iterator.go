@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// sstableIterator streams all entries of a table in order.
+type sstableIterator struct {
+	blocks  *tableIterator
+	started bool
+	entry   decodedEntry
+}
+
+func (t *SSTable) newIterator() *sstableIterator {
+	return &sstableIterator{blocks: t.newTableIterator(false)}
+}
+
+func (it *sstableIterator) Next() bool {
+	if it.started {
+		it.blocks.Next()
+	} else {
+		it.blocks.SeekToFirst()
+		it.started = true
+	}
+
+	if !it.blocks.Valid() {
+		return false
+	}
+
+	it.entry = *it.blocks.Entry()
+
+	return true
+}
+
+func (it *sstableIterator) Err() error {
+	return it.blocks.Err()
+}
+
+// tableIterator is a seekable bidirectional iterator over the entries of a
+// table, it reads one block at a time.
+type tableIterator struct {
+	table     *SSTable
+	block     int
+	entries   []decodedEntry
+	pos       int
+	err       error
+	fillCache bool
+}
+
+// newTableIterator returns an iterator over the table, fillCache tells whether
+// blocks it reads are added to the block cache.
+func (t *SSTable) newTableIterator(fillCache bool) *tableIterator {
+	return &tableIterator{table: t, block: -1, fillCache: fillCache}
+}
+
+func (it *tableIterator) loadBlock(idx int) bool {
+	it.entries = nil
+	it.block = idx
+
+	if idx < 0 || idx >= len(it.table.index) || it.err != nil {
+		return false
+	}
+
+	entries, err := it.table.readEntries(idx, it.fillCache)
+	if err != nil {
+		it.err = err
+		it.block = -1
+		return false
+	}
+	it.entries = entries
+
+	return true
+}
+
+func (it *tableIterator) SeekGE(key []byte) {
+	i := sort.Search(len(it.table.index), func(i int) bool {
+		return it.table.index[i].Key > string(key)
+	})
+
+	if !it.loadBlock(max(i-1, 0)) {
+		return
+	}
+
+	it.pos = sort.Search(len(it.entries), func(i int) bool {
+		return bytes.Compare(it.entries[i].key, key) >= 0
+	})
+
+	if it.pos == len(it.entries) {
+		it.nextBlock()
+	}
+}
+
+func (it *tableIterator) SeekLT(key []byte) {
+	i := sort.Search(len(it.table.index), func(i int) bool {
+		return it.table.index[i].Key >= string(key)
+	})
+
+	if !it.loadBlock(i - 1) {
+		return
+	}
+
+	it.pos = sort.Search(len(it.entries), func(i int) bool {
+		return bytes.Compare(it.entries[i].key, key) >= 0
+	}) - 1
+}
+
+func (it *tableIterator) SeekToFirst() {
+	if it.loadBlock(0) {
+		it.pos = 0
+	}
+}
+
+func (it *tableIterator) SeekToLast() {
+	if it.loadBlock(len(it.table.index) - 1) {
+		it.pos = len(it.entries) - 1
+	}
+}
+
+func (it *tableIterator) Next() {
+	it.pos++
+	if it.pos >= len(it.entries) {
+		it.nextBlock()
+	}
+}
+
+func (it *tableIterator) Prev() {
+	it.pos--
+	if it.pos < 0 && it.loadBlock(it.block-1) {
+		it.pos = len(it.entries) - 1
+	}
+}
+
+func (it *tableIterator) nextBlock() {
+	if it.loadBlock(it.block + 1) {
+		it.pos = 0
+	}
+}
+
+func (it *tableIterator) Valid() bool {
+	return it.pos >= 0 && it.pos < len(it.entries)
+}
+
+func (it *tableIterator) Entry() *decodedEntry {
+	return &it.entries[it.pos]
+}
+
+func (it *tableIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeTestTable encodes the entries, ordered by key and newest first, in an
+// earlier table format. Format 0 entries carry no sequence numbers.
+func encodeTestTable(version uint32, entries []decodedEntry, blockEntries int) []byte {
+	var buf []byte
+	var index []IndexEntry
+	filter := NewBloomFilter(len(entries), 0.01)
+
+	for start := 0; start < len(entries); {
+		end := min(start+blockEntries, len(entries))
+		for end < len(entries) && string(entries[end].key) == string(entries[end-1].key) {
+			end++
+		}
+
+		index = append(index, IndexEntry{Key: string(entries[start].key), Offset: int64(len(buf))})
+		block := encodeTestBlock(version, entries[start:end])
+
+		switch {
+		case version == legacyTableFormatVersion:
+			buf = append(buf, block...)
+		case version == checksumTableFormatVersion:
+			buf = append(buf, block...)
+			buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(block, crc32cTable))
+		default:
+			block = append([]byte{byte(CompressionNone)}, block...)
+			buf = append(buf, block...)
+			buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(block, crc32cTable))
+		}
+
+		for _, e := range entries[start:end] {
+			filter.Add(e.key)
+		}
+		start = end
+	}
+
+	var indexBuf []byte
+	for _, ie := range index {
+		if version >= varintTableFormatVersion {
+			indexBuf = appendLengthPrefixed(indexBuf, ie.Key)
+			indexBuf = binary.AppendUvarint(indexBuf, uint64(ie.Offset))
+			continue
+		}
+
+		indexBuf = binary.BigEndian.AppendUint16(indexBuf, uint16(len(ie.Key)))
+		indexBuf = append(indexBuf, ie.Key...)
+		indexBuf = binary.BigEndian.AppendUint64(indexBuf, uint64(ie.Offset))
+	}
+
+	if version == legacyTableFormatVersion {
+		filterOffset := len(buf)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(filter)))
+		buf = append(buf, filter...)
+		indexOffset := len(buf)
+		buf = append(buf, indexBuf...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(filterOffset))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(indexOffset))
+
+		return buf
+	}
+
+	section := func(data []byte) sectionHandle {
+		h := sectionHandle{offset: int64(len(buf)), size: int64(len(data))}
+		buf = append(buf, data...)
+		buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(data, crc32cTable))
+
+		return h
+	}
+
+	handles := []sectionHandle{section(filter), {}, section(indexBuf)}
+	if version >= rangeDelTableFormatVersion {
+		handles = append(handles, sectionHandle{})
+	}
+
+	footerStart := len(buf)
+	for _, h := range handles {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(h.offset))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(h.size))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, version)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf[footerStart:], crc32cTable))
+	buf = binary.BigEndian.AppendUint64(buf, tableMagic)
+
+	return buf
+}
+
+func encodeTestBlock(version uint32, entries []decodedEntry) []byte {
+	var block []byte
+	var restarts []uint32
+	var prev []byte
+
+	for i, e := range entries {
+		legacyKind := uint16(0)
+		if e.kind == entryKindDelete {
+			legacyKind = entryTombstone
+		}
+
+		if version < prefixTableFormatVersion {
+			if version > legacyTableFormatVersion {
+				legacyKind |= entryHasSeq
+			}
+
+			block = binary.BigEndian.AppendUint16(block, uint16(len(e.key)))
+			block = binary.BigEndian.AppendUint32(block, uint32(len(e.value)))
+			block = binary.BigEndian.AppendUint32(block, e.flags)
+			block = binary.BigEndian.AppendUint16(block, legacyKind)
+			if version > legacyTableFormatVersion {
+				block = binary.BigEndian.AppendUint64(block, e.seq)
+			}
+			block = append(block, e.key...)
+			block = append(block, e.value...)
+			continue
+		}
+
+		shared := 0
+		if i%blockRestartInterval == 0 {
+			restarts = append(restarts, uint32(len(block)))
+		} else {
+			shared = sharedPrefixLen(string(prev), string(e.key))
+		}
+		prev = e.key
+
+		switch version {
+		case prefixTableFormatVersion:
+			block = binary.BigEndian.AppendUint16(block, uint16(shared))
+			block = binary.BigEndian.AppendUint16(block, uint16(len(e.key)-shared))
+			block = binary.BigEndian.AppendUint32(block, uint32(len(e.value)))
+			block = binary.BigEndian.AppendUint32(block, e.flags)
+			block = binary.BigEndian.AppendUint16(block, legacyKind)
+			block = binary.BigEndian.AppendUint64(block, e.seq)
+			block = append(block, e.key[shared:]...)
+			block = append(block, e.value...)
+		case varintTableFormatVersion:
+			block = binary.AppendUvarint(block, uint64(shared))
+			block = binary.AppendUvarint(block, uint64(len(e.key)-shared))
+			block = binary.AppendUvarint(block, uint64(len(e.value)))
+			block = append(block, e.kind)
+			block = binary.AppendUvarint(block, uint64(e.flags))
+			block = binary.AppendUvarint(block, e.seq)
+			block = append(block, e.key[shared:]...)
+			block = append(block, e.value...)
+		default:
+			block = appendEntry(block, string(e.key), shared, e.value, e.flags, e.kind, e.seq, e.expiresAt)
+		}
+	}
+
+	if version >= prefixTableFormatVersion {
+		for _, r := range restarts {
+			block = binary.BigEndian.AppendUint32(block, r)
+		}
+		block = binary.BigEndian.AppendUint32(block, uint32(len(restarts)))
+	}
+
+	return block
+}
+
+func TestReadTableFormats(t *testing.T) {
+	const tableSeq = 1000
+
+	for version := legacyTableFormatVersion; version <= tableFormatVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			// Format 0 has no sequence numbers, so every key has one version.
+			withSeq := version > legacyTableFormatVersion
+			seqOf := func(seq uint64) uint64 {
+				if withSeq {
+					return seq
+				}
+
+				return tableSeq
+			}
+
+			var entries []decodedEntry
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("key%03d", i))
+				e := decodedEntry{key: key, value: []byte(fmt.Sprintf("v%d", i)), flags: uint32(i), kind: entryKindPut, seq: seqOf(uint64(100 + i))}
+				if i == 7 {
+					e = decodedEntry{key: key, kind: entryKindDelete, seq: seqOf(107)}
+				}
+				if i == 9 && version >= ttlTableFormatVersion {
+					e.expiresAt = 1
+				}
+				entries = append(entries, e)
+
+				if withSeq && (i == 5 || i == 7) {
+					entries = append(entries, decodedEntry{key: key, value: []byte("old"), kind: entryKindPut, seq: 20})
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), tableFileName("", 1))
+			if version == tableFormatVersion {
+				table, err := newSSTable(path, TableOptions{BlockSize: 256}, nil)
+				if err != nil {
+					t.Fatalf("newSSTable: %v", err)
+				}
+				for _, e := range entries {
+					err = table.add(string(e.key), e.value, e.flags, e.kind, e.seq, e.expiresAt)
+					if err != nil {
+						t.Fatalf("add: %v", err)
+					}
+				}
+				err = table.Finish()
+				if err == nil {
+					err = table.Close()
+				}
+				if err != nil {
+					t.Fatalf("Finish: %v", err)
+				}
+			} else {
+				err := os.WriteFile(path, encodeTestTable(version, entries, 20), 0644)
+				if err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			table, err := OpenSSTable(path, TableOptions{BlockSize: 256})
+			if err != nil {
+				t.Fatalf("OpenSSTable: %v", err)
+			}
+			defer table.Close()
+			table.largestSeq = tableSeq
+
+			if table.formatVersion != version || table.minKey != "key000" || table.maxKey != "key049" {
+				t.Fatalf("opened format %d with keys [%s, %s]", table.formatVersion, table.minKey, table.maxKey)
+			}
+
+			if len(table.index) < 2 {
+				t.Fatalf("got %d blocks, want several", len(table.index))
+			}
+
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key%03d", i)
+				e, found, err := table.getEntry(key, math.MaxUint64)
+				if err != nil || !found {
+					t.Fatalf("getEntry(%q) = %v, %v", key, found, err)
+				}
+
+				deleted := i == 7 || i == 9 && version >= ttlTableFormatVersion
+				if deleted {
+					if e.kind != entryKindDelete {
+						t.Fatalf("getEntry(%q) kind %d, want a tombstone", key, e.kind)
+					}
+					continue
+				}
+
+				if e.kind != entryKindPut || string(e.value) != fmt.Sprintf("v%d", i) || e.flags != uint32(i) || e.seq != seqOf(uint64(100+i)) {
+					t.Fatalf("getEntry(%q) = %q flags %d seq %d", key, e.value, e.flags, e.seq)
+				}
+			}
+
+			if withSeq {
+				e, found, err := table.getEntry("key007", 50)
+				if err != nil || !found || string(e.value) != "old" {
+					t.Fatalf("older version = %q, %v, %v", e.value, found, err)
+				}
+			}
+
+			it := table.newIterator()
+			n := 0
+			for it.Next() {
+				if string(it.entry.key) != string(entries[n].key) || it.entry.seq != entries[n].seq {
+					t.Fatalf("entry %d is %s@%d, want %s@%d", n, it.entry.key, it.entry.seq, entries[n].key, entries[n].seq)
+				}
+				n++
+			}
+			if it.Err() != nil || n != len(entries) {
+				t.Fatalf("iterated %d entries, want %d: %v", n, len(entries), it.Err())
+			}
+		})
+	}
+}
@@ -1,245 +1,565 @@
 package storage
 
 import (
-	"fmt"
+	"container/list"
 	"hash/fnv"
 	"log"
 	"os"
-	"path/filepath"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+type Options struct {
+	DataDir         string
+	BlockSize       int64
+	MaxMemSize      int64
+	ShardsCount     uint32
+	WALSyncPolicy   WALSyncPolicy
+	WALSyncInterval time.Duration
+	PrefixExtractor PrefixExtractor
+	MergeOperator   MergeOperator
+	Compression     Compression
+	// BlockCacheSize bounds the memory of cached blocks, zero means
+	// DefaultBlockCacheSize and a negative size disables the cache.
+	BlockCacheSize int64
+	// MaxOpenFiles bounds the open table files, zero means DefaultMaxOpenFiles
+	// and a negative value keeps every table open.
+	MaxOpenFiles int
+	// MaxImmutableMemtables is the number of memtables waiting for a flush
+	// before writes stall, zero means DefaultMaxImmutableMemtables.
+	MaxImmutableMemtables int
+
+	// Writes are delayed by WriteSlowdownDelay once a slowdown threshold is
+	// reached and wait up to WriteStallTimeout once a stop threshold is
+	// reached. L0 triggers only apply to leveled compaction, zero values mean
+	// the defaults.
+	L0SlowdownWritesTrigger    int
+	L0StopWritesTrigger        int
+	SoftPendingCompactionBytes int64
+	HardPendingCompactionBytes int64
+	WriteSlowdownDelay         time.Duration
+	WriteStallTimeout          time.Duration
+
+	CompactionStrategy     CompactionStrategy
+	CompactionMinThreshold int
+	CompactionMaxThreshold int
+	CompactionBucketLow    float64
+	CompactionBucketHigh   float64
+	CompactionMinTableSize int64
+	CompactionRateLimit    int64
+	CompactionInterval     time.Duration
+	CompactionFilters      []CompactionFilter
+
+	MaxLevels           int
+	L0CompactionTrigger int
+	LevelBaseSize       int64
+	LevelSizeMultiplier int
+	TargetFileSize      int64
+}
+
 type Storage struct {
-	tablesMutex sync.RWMutex
-	flushMutex  sync.Mutex
-	shards      []*Shard
-	shardsSize  int64
-	tables      []*SSTable
-	dataDir     string
-	blockSize   int64
-	maxMemSize  int64
-	shardsCount uint32
+	tablesMutex     sync.RWMutex
+	flushMutex      sync.Mutex
+	flushCond       *sync.Cond
+	immutablesMutex sync.RWMutex
+	manifestMutex   sync.Mutex
+	snapshotsMutex  sync.Mutex
+	shards          []*Shard
+	shardsSize      int64
+	immutables      []*immutableMemtable
+	levels          [][]*SSTable
+	wal             *WAL
+	manifest        *manifest
+	compactor       *compactor
+	stall           *writeController
+	commits         *commitPipeline
+	snapshots       *list.List
+	flushCh         chan struct{}
+	flushDone       chan struct{}
+	flushErr        error
+	nextFileNumber  uint64
+	lastSequence    uint64
+	walSegment      uint64
+	dataDir         string
+	tableOptions    TableOptions
+	maxMemSize      int64
+	maxImmutables   int
+	shardsCount     uint32
+	mergeOperator   MergeOperator
+
+	// rangeTombstones belong to the active memtable, rangeTombstoneTables
+	// counts the live tables with range tombstones.
+	rangeTombstones      atomic.Pointer[rangeTombstoneList]
+	rangeTombstoneTables int64
 }
 
+// Shard holds the active memtable of a part of the key space. Its skiplist is
+// read without locks, writers share the read lock so that rotation and batches
+// can exclude them by taking the write lock.
 type Shard struct {
 	mu       sync.RWMutex
-	skipList *SkipList
+	skipList atomic.Pointer[SkipList]
 }
 
-func NewStorage(dataDir string, blockSize int64, maxMemSize int64, shardsCount uint32) (*Storage, error) {
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+func (sh *Shard) memtable() *SkipList {
+	return sh.skipList.Load()
+}
+
+func NewStorage(opts Options) (*Storage, error) {
+	if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
 		return nil, err
 	}
 
 	s := &Storage{
-		shardsCount: shardsCount,
-		dataDir:     dataDir,
-		blockSize:   blockSize,
-		maxMemSize:  maxMemSize,
-		shards:      make([]*Shard, shardsCount),
+		shardsCount: opts.ShardsCount,
+		dataDir:     opts.DataDir,
+		maxMemSize:  opts.MaxMemSize,
+		tableOptions: TableOptions{
+			BlockSize:       opts.BlockSize,
+			PrefixExtractor: opts.PrefixExtractor,
+			Compression:     opts.Compression,
+			BlockCache:      newStorageBlockCache(opts.BlockCacheSize),
+			files:           newTableCache(opts.MaxOpenFiles),
+		},
+		maxImmutables: opts.MaxImmutableMemtables,
+		mergeOperator: opts.MergeOperator,
+		shards:        make([]*Shard, opts.ShardsCount),
+		levels:        make([][]*SSTable, 1),
+		snapshots:     list.New(),
+		flushCh:       make(chan struct{}, 1),
+		flushDone:     make(chan struct{}),
 	}
+	s.flushCond = sync.NewCond(&s.flushMutex)
+	s.rangeTombstones.Store(&rangeTombstoneList{})
 
-	for i := 0; i < int(shardsCount); i++ {
-		s.shards[i] = &Shard{
-			skipList: NewSkipList(),
-		}
+	if s.maxImmutables <= 0 {
+		s.maxImmutables = DefaultMaxImmutableMemtables
+	}
+
+	for i := 0; i < int(opts.ShardsCount); i++ {
+		s.shards[i] = &Shard{}
+		s.shards[i].skipList.Store(NewSkipList())
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
 	}
 
-	if err := s.loadSSTables(); err != nil {
+	if err := s.replayWAL(); err != nil {
 		return nil, err
 	}
+	s.commits = newCommitPipeline(s.lastSequence)
+
+	wal, err := OpenWAL(opts.DataDir, opts.WALSyncPolicy, opts.WALSyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = wal
+
+	s.compactor = newCompactor(s, opts)
+	s.stall = newWriteController(opts, s.maxImmutables)
+	s.updateWriteStall()
+	s.compactor.start()
+
+	go s.flushLoop()
 
 	return s, nil
 }
 
 func (s *Storage) getShard(key string) (*Shard, error) {
+	idx, err := s.shardIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shards[idx], nil
+}
+
+func (s *Storage) shardIndex(key string) (uint32, error) {
 	h := fnv.New32a()
 	_, err := h.Write([]byte(key))
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return s.shards[h.Sum32()%s.shardsCount], nil
+	return h.Sum32() % s.shardsCount, nil
 }
 
 func (s *Storage) Close() error {
-	err := s.flush(false)
+	s.compactor.close()
+
+	err := s.flush()
+	close(s.flushCh)
+	<-s.flushDone
 	if err != nil {
 		return err
 	}
 
-	err = s.closeTables()
+	err = s.wal.Close()
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (s *Storage) loadSSTables() error {
-	files, err := os.ReadDir(s.dataDir)
+	err = s.manifest.close()
 	if err != nil {
 		return err
 	}
 
-	var sstFiles []string
-	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(f.Name(), ".sst") {
-			sstFiles = append(sstFiles, filepath.Join(s.dataDir, f.Name()))
-		}
+	err = s.closeTables()
+	if err != nil {
+		return err
 	}
 
-	sort.Strings(sstFiles)
+	return nil
+}
+
+func (s *Storage) replayWAL() error {
+	log.Println("Starting wal replay...")
 
-	for _, path := range sstFiles {
-		err = s.loadSSTable(path)
+	var records int
+	err := ReplayWAL(s.dataDir, s.walSegment, func(rec walRecord) error {
+		shard, err := s.getShard(rec.key)
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
-}
+		if rec.seq == 0 {
+			rec.seq = s.lastSequence + 1
+		}
+		s.lastSequence = max(s.lastSequence, rec.seq)
 
-func (s *Storage) loadSSTable(path string) error {
-	s.tablesMutex.Lock()
-	defer s.tablesMutex.Unlock()
+		s.shardsSize += s.insert(shard, rec)
+		records++
 
-	table, err := OpenSSTable(path, s.blockSize)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	s.tables = append(s.tables, table)
+	log.Printf("Wal replay is end, %d records restored", records)
 
 	return nil
 }
 
 func (s *Storage) Set(key string, value []byte, flags uint32) error {
-	shard, err := s.getShard(key)
+	return s.apply(walRecord{kind: walRecordSet, key: key, value: value, flags: flags})
+}
+
+// SetWithExpiry stores a value that reads as missing from expiresAt on, a unix
+// time in seconds. Zero means the value never expires.
+func (s *Storage) SetWithExpiry(key string, value []byte, flags uint32, expiresAt int64) error {
+	return s.apply(walRecord{kind: walRecordSet, key: key, value: value, flags: flags, expiresAt: expiresAt})
+}
+
+func (s *Storage) apply(rec walRecord) error {
+	err := checkEntrySize(rec.key, rec.value)
 	if err != nil {
 		return err
 	}
 
-	shard.mu.Lock()
-	oldSize := shard.skipList.size
-	shard.skipList.Set(key, value, flags, false)
-	newSize := shard.skipList.size
-	shard.mu.Unlock()
+	err = s.stall.admit()
+	if err != nil {
+		return err
+	}
 
-	shardsSize := atomic.AddInt64(&s.shardsSize, newSize-oldSize)
+	shard, err := s.getShard(rec.key)
+	if err != nil {
+		return err
+	}
 
-	if shardsSize >= s.maxMemSize {
-		err = s.flush(true)
-		if err != nil {
-			return err
-		}
+	shard.mu.RLock()
+	size, err := s.commit(shard, &rec)
+	shard.mu.RUnlock()
+
+	s.commits.finish(rec.seq)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	atomic.AddInt64(&s.shardsSize, size)
+
+	return s.maybeScheduleFlush()
 }
 
-func (s *Storage) Get(key string) ([]byte, uint32, bool, error) {
-	shard, err := s.getShard(key)
+// commit gives the record its sequence number, logs it and inserts it into the
+// memtable. The caller holds the shard lock and finishes the sequence number.
+func (s *Storage) commit(shard *Shard, rec *walRecord) (int64, error) {
+	rec.seq = atomic.AddUint64(&s.lastSequence, 1)
+
+	err := s.wal.Append(*rec)
 	if err != nil {
+		return 0, err
+	}
+
+	return s.insert(shard, *rec), nil
+}
+
+// insert puts the record into the active memtable and returns the bytes it
+// took. The caller holds the shard lock, range tombstones go to the memtable
+// list under the lock of the shard of their start key.
+func (s *Storage) insert(shard *Shard, rec walRecord) int64 {
+	if rec.kind == walRecordRangeDelete {
+		s.rangeTombstones.Load().add(rangeTombstone{start: rec.key, end: string(rec.value), seq: rec.seq})
+		return 0
+	}
+
+	return shard.memtable().set(rec.key, rec.value, rec.flags, rec.kind, rec.seq, rec.expiresAt)
+}
+
+// Get returns the live value of the key. The value shares memory with the
+// memtable or a cached block and must not be modified.
+func (s *Storage) Get(key string) ([]byte, uint32, bool, error) {
+	return s.get(key, s.commits.visible())
+}
+
+// get returns the newest version of the key with a sequence number not above seq.
+func (s *Storage) get(key string, seq uint64) ([]byte, uint32, bool, error) {
+	e, found, err := s.lookup(key, seq)
+	if err != nil || !found {
 		return nil, 0, false, err
 	}
 
-	shard.mu.RLock()
-	val, flags, isTomb, found := shard.skipList.Get(key)
-	shard.mu.RUnlock()
+	return e.value, e.flags, true, nil
+}
 
-	if found {
-		if isTomb {
-			return nil, 0, false, nil
+// lookup returns the live value of the key at seq as a put entry.
+func (s *Storage) lookup(key string, seq uint64) (decodedEntry, bool, error) {
+	idx, err := s.shardIndex(key)
+	if err != nil {
+		return decodedEntry{}, false, err
+	}
+
+	if s.hasRangeTombstones() {
+		return s.getResolved(key, idx, seq)
+	}
+
+	e, found := s.getFromMemtables(key, idx, seq)
+	if !found {
+		s.tablesMutex.RLock()
+		e, found, err = s.getFromTables(key, seq)
+		s.tablesMutex.RUnlock()
+
+		if err != nil {
+			return decodedEntry{}, false, err
 		}
+	}
 
-		return val, flags, true, nil
+	if found && e.kind == entryKindMerge {
+		return s.getResolved(key, idx, seq)
 	}
 
+	if !found || e.kind == entryKindDelete {
+		return decodedEntry{}, false, nil
+	}
+
+	return e, true, nil
+}
+
+// getResolved collects the versions of the key down to the first put or
+// tombstone, applies the range tombstones covering the key and folds the merge
+// operands among them. The tables lock is held throughout, so a compaction
+// cannot fold the versions being read.
+func (s *Storage) getResolved(key string, idx uint32, seq uint64) (decodedEntry, bool, error) {
 	s.tablesMutex.RLock()
 	defer s.tablesMutex.RUnlock()
 
-	for i := len(s.tables) - 1; i >= 0; i-- {
-		val, flags, isTomb, err = s.tables[i].Get(key)
+	tombstones := s.coveringRangeTombstones(key, seq)
+
+	readSeq := seq
+	inTables := false
+
+	var versions []decodedEntry
+	for {
+		var e decodedEntry
+		found := false
+		if !inTables {
+			e, found = s.getFromMemtables(key, idx, seq)
+		}
+
+		if !found {
+			// An immutable memtable stays readable while its tables are
+			// installed and compacted, so the tables are read from the
+			// start. A table version at or above the memtable ones already
+			// holds them, possibly folded into a value.
+			if !inTables {
+				seq = readSeq
+				inTables = true
+			}
+
+			var err error
+			e, found, err = s.getFromTables(key, seq)
+			if err != nil {
+				return decodedEntry{}, false, err
+			}
+
+			for found && len(versions) > 0 && versions[len(versions)-1].seq <= e.seq {
+				versions = versions[:len(versions)-1]
+			}
+		}
+
+		if !found {
+			break
+		}
+
+		versions = append(versions, e)
+		if e.kind != entryKindMerge || e.seq == 0 {
+			break
+		}
+		seq = e.seq - 1
+	}
+	versions = withRangeTombstones(versions, tombstones)
+
+	return resolveVersions(s.mergeOperator, key, versions)
+}
+
+func (s *Storage) getFromMemtables(key string, idx uint32, seq uint64) (decodedEntry, bool) {
+	e, found := s.shards[idx].memtable().getEntry(key, seq)
+	if found {
+		return e, true
+	}
+
+	return s.getFromImmutables(key, idx, seq)
+}
+
+// getFromTables returns the newest version of the key in the tables, its value
+// shares memory with the block, which may be cached. The caller holds the
+// tables lock.
+func (s *Storage) getFromTables(key string, seq uint64) (decodedEntry, bool, error) {
+	for level, tables := range s.levels {
+		var e decodedEntry
+		var found bool
+		var err error
+
+		if level == 0 {
+			e, found, err = getFromLevel0(tables, key, seq)
+		} else if table := findTable(tables, key); table != nil {
+			e, found, err = table.getEntry(key, seq)
+		}
+
 		if err != nil {
-			return nil, 0, false, err
+			return decodedEntry{}, false, err
 		}
 
-		if isTomb {
-			return nil, 0, false, nil
+		if found {
+			e.value = e.value[:len(e.value):len(e.value)]
+			return e, true, nil
+		}
+	}
+
+	return decodedEntry{}, false, nil
+}
+
+// getFromLevel0 returns the newest version among the overlapping L0 tables. The
+// tables are ordered by their largest sequence number, which does not order
+// the versions of a single key: a compaction output can sort after a table
+// flushed later from another shard, so every candidate table is consulted.
+func getFromLevel0(tables []*SSTable, key string, seq uint64) (decodedEntry, bool, error) {
+	var best decodedEntry
+	found := false
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		if key < t.minKey || key > t.maxKey || t.smallestSeq > seq {
+			continue
+		}
+
+		if found && t.largestSeq <= best.seq {
+			continue
+		}
+
+		e, ok, err := t.getEntry(key, seq)
+		if err != nil {
+			return decodedEntry{}, false, err
 		}
 
-		if val != nil {
-			return val, flags, true, nil
+		if ok && (!found || e.seq > best.seq) {
+			best = e
+			found = true
 		}
 	}
 
-	return nil, 0, false, nil
+	return best, found, nil
 }
 
-func (s *Storage) Delete(key string) error {
-	shard, err := s.getShard(key)
-	if err != nil {
-		return err
+// findTable returns the table of a sorted level whose range holds the key. A
+// table with range tombstones ends at the end of their range, which is the
+// first key of the next table, so the next table is preferred.
+func findTable(tables []*SSTable, key string) *SSTable {
+	i := sort.Search(len(tables), func(i int) bool {
+		return tables[i].maxKey >= key
+	})
+
+	if i+1 < len(tables) && tables[i+1].minKey <= key {
+		i++
 	}
 
-	shard.mu.Lock()
-	shard.skipList.Delete(key)
-	shard.mu.Unlock()
+	if i < len(tables) && tables[i].minKey <= key {
+		return tables[i]
+	}
 
 	return nil
 }
 
-func (s *Storage) flush(load bool) error {
-	lock := s.flushMutex.TryLock()
-	if lock {
-		defer s.flushMutex.Unlock()
+func (s *Storage) Delete(key string) error {
+	return s.apply(walRecord{kind: walRecordDelete, key: key})
+}
 
-		shardsSize := atomic.LoadInt64(&s.shardsSize)
-		if shardsSize > 0 {
-			log.Println("Starting data flush...")
+func (s *Storage) applyCompaction(comp *compaction, outputs []*SSTable) error {
+	edit := &versionEdit{}
+
+	for _, t := range comp.inputs {
+		edit.deleteTable(comp.level, t.fileNum)
+	}
 
-			for i := 0; i < int(s.shardsCount); i++ {
-				s.shards[i].mu.Lock()
+	for _, t := range comp.overlaps {
+		edit.deleteTable(comp.outputLevel, t.fileNum)
+	}
 
-				if s.shards[i].skipList.size == 0 {
-					s.shards[i].mu.Unlock()
-					continue
-				}
+	for _, t := range outputs {
+		edit.addTable(comp.outputLevel, t.tableMeta)
+	}
 
-				name := fmt.Sprintf("%d.%d.sst", i, time.Now().UnixNano())
-				path := filepath.Join(s.dataDir, name)
+	err := s.logAndApply(edit, outputs)
+	if err != nil {
+		return err
+	}
+	s.updateWriteStall()
 
-				err := CreateSSTable(path, s.blockSize, s.shards[i].skipList)
-				if err != nil {
-					s.shards[i].mu.Unlock()
-					return err
-				}
+	return nil
+}
 
-				atomic.AddInt64(&s.shardsSize, -s.shards[i].skipList.size)
+func (s *Storage) CompactionStats() CompactionStats {
+	stats := s.compactor.statsSnapshot()
 
-				s.shards[i].skipList = NewSkipList()
-				s.shards[i].mu.Unlock()
+	s.tablesMutex.RLock()
+	stats.Levels = make([]LevelStats, len(s.levels))
+	for level, tables := range s.levels {
+		stats.Levels[level] = LevelStats{
+			Tables: len(tables),
+			Size:   levelSize(tables),
+		}
 
-				if load {
-					err = s.loadSSTable(path)
-					if err != nil {
-						return err
-					}
-				}
+		stats.LiveTables += len(tables)
+		stats.LiveTablesSize += stats.Levels[level].Size
+	}
+	s.tablesMutex.RUnlock()
 
-			}
+	return stats
+}
 
-			log.Println("Data flush is end")
-		}
+func (s *Storage) BlockCacheStats() BlockCacheStats {
+	if s.tableOptions.BlockCache == nil {
+		return BlockCacheStats{}
 	}
 
-	return nil
+	return s.tableOptions.BlockCache.Stats()
+}
+
+func (s *Storage) SetCompactionRateLimit(bytesPerSec int64) {
+	s.compactor.limiter.setRate(bytesPerSec)
 }
 
 func (s *Storage) closeTables() error {
@@ -247,10 +567,12 @@ func (s *Storage) closeTables() error {
 	defer s.tablesMutex.Unlock()
 
 	log.Println("Starting tables close...")
-	for i := range s.tables {
-		err := s.tables[i].Close()
-		if err != nil {
-			return err
+	for _, tables := range s.levels {
+		for i := range tables {
+			err := tables[i].Close()
+			if err != nil {
+				return err
+			}
 		}
 	}
 	log.Println("Tables are closed")
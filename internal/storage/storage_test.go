@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T, opts Options) *Storage {
+	t.Helper()
+
+	if opts.DataDir == "" {
+		opts.DataDir = t.TempDir()
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = 4096
+	}
+	if opts.MaxMemSize == 0 {
+		opts.MaxMemSize = 1 << 20
+	}
+	if opts.ShardsCount == 0 {
+		opts.ShardsCount = 4
+	}
+	if opts.CompactionInterval == 0 {
+		opts.CompactionInterval = time.Hour
+	}
+
+	s, err := NewStorage(opts)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if s.compactor != nil {
+			s.Close()
+		}
+	})
+
+	return s
+}
+
+// nextTestSeq hands out a sequence number the way a committed write does.
+func nextTestSeq(s *Storage) uint64 {
+	seq := atomic.AddUint64(&s.lastSequence, 1)
+	s.commits.finish(seq)
+
+	return seq
+}
+
+// addTestTable writes the entries, ordered by key and newest first, into a new
+// table of the level.
+func addTestTable(t *testing.T, s *Storage, level int, entries ...decodedEntry) *SSTable {
+	t.Helper()
+
+	fileNum := s.newFileNumber()
+	table, err := newSSTable(tableFileName(s.dataDir, fileNum), s.tableOptions, nil)
+	if err != nil {
+		t.Fatalf("newSSTable: %v", err)
+	}
+	table.fileNum = fileNum
+
+	for _, e := range entries {
+		err = table.add(string(e.key), e.value, e.flags, e.kind, e.seq, e.expiresAt)
+		if err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	err = table.Finish()
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	err = table.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	opened, err := table.reopen()
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+
+	edit := &versionEdit{}
+	edit.addTable(level, opened.tableMeta)
+
+	err = s.logAndApply(edit, []*SSTable{opened})
+	if err != nil {
+		t.Fatalf("logAndApply: %v", err)
+	}
+
+	return opened
+}
+
+func mustGet(t *testing.T, s *Storage, key string) (string, bool) {
+	t.Helper()
+
+	value, _, found, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+
+	return string(value), found
+}
+
+// crashTestStorage stops the storage without flushing its memtables, as if the
+// process died after the WAL was written.
+func crashTestStorage(t *testing.T, s *Storage) {
+	t.Helper()
+
+	s.compactor.close()
+	s.compactor = nil
+	close(s.flushCh)
+	<-s.flushDone
+
+	err := s.wal.Close()
+	if err != nil {
+		t.Fatalf("wal close: %v", err)
+	}
+
+	err = s.manifest.close()
+	if err != nil {
+		t.Fatalf("manifest close: %v", err)
+	}
+
+	err = s.closeTables()
+	if err != nil {
+		t.Fatalf("closeTables: %v", err)
+	}
+}
+
+func closeTestStorage(t *testing.T, s *Storage) {
+	t.Helper()
+
+	err := s.Close()
+	s.compactor = nil
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// compactTestStorage stops the background compactions and runs compactions
+// until none is left to do.
+func compactTestStorage(t *testing.T, s *Storage) {
+	t.Helper()
+
+	s.compactor.close()
+	s.compactor.stop = make(chan struct{})
+
+	for {
+		compacted, err := s.compactor.compactOnce()
+		if err != nil {
+			t.Fatalf("compactOnce: %v", err)
+		}
+
+		if !compacted {
+			return
+		}
+	}
+}
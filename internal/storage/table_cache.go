@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"sync"
+)
+
+const DefaultMaxOpenFiles = 1000
+
+// tableCache bounds the number of open table files. The index and the filters
+// of a table stay in memory, only its file handle is closed when it is the
+// least recently used one and reopened on the next read.
+type tableCache struct {
+	mu       sync.Mutex
+	capacity int
+	open     int
+	lru      list.List
+}
+
+func newTableCache(maxOpenFiles int) *tableCache {
+	if maxOpenFiles < 0 {
+		return nil
+	}
+
+	if maxOpenFiles == 0 {
+		maxOpenFiles = DefaultMaxOpenFiles
+	}
+
+	return &tableCache{capacity: maxOpenFiles}
+}
+
+// add registers the just opened file of the table, it stays pinned until the
+// first release.
+func (c *tableCache) add(t *SSTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.open++
+	t.fileRefs = 1
+	c.evict()
+}
+
+// acquire returns the open file of the table and pins it until release.
+func (c *tableCache) acquire(t *SSTable) (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.f == nil {
+		f, err := os.OpenFile(t.path, os.O_RDONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		t.f = f
+		c.open++
+	}
+
+	if t.lruElem != nil {
+		c.lru.Remove(t.lruElem)
+		t.lruElem = nil
+	}
+	t.fileRefs++
+	c.evict()
+
+	return t.f, nil
+}
+
+func (c *tableCache) release(t *SSTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.fileRefs--
+	if t.fileRefs == 0 && t.f != nil {
+		t.lruElem = c.lru.PushFront(t)
+	}
+	c.evict()
+}
+
+// close closes the file of a table that is no longer read.
+func (c *tableCache) close(t *SSTable) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.lruElem != nil {
+		c.lru.Remove(t.lruElem)
+		t.lruElem = nil
+	}
+
+	if t.f == nil {
+		return nil
+	}
+
+	err := t.f.Close()
+	t.f = nil
+	c.open--
+
+	return err
+}
+
+// evict closes the least recently used files that are not read at the moment
+// until the limit is met. Files in use are never closed, so the limit can be
+// exceeded briefly.
+func (c *tableCache) evict() {
+	for c.open > c.capacity && c.lru.Len() > 0 {
+		t := c.lru.Remove(c.lru.Back()).(*SSTable)
+		t.lruElem = nil
+
+		err := t.f.Close()
+		if err != nil {
+			log.Printf("Error during table file close %v", err)
+		}
+		t.f = nil
+		c.open--
+	}
+}
+
+func (t *SSTable) acquireFile() (*os.File, error) {
+	if t.files == nil {
+		return t.f, nil
+	}
+
+	return t.files.acquire(t)
+}
+
+func (t *SSTable) releaseFile() {
+	if t.files != nil {
+		t.files.release(t)
+	}
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+var ErrNotNumeric = errors.New("storage: value is not a decimal number")
+
+// maxUpdateAttempts is how often update reads a key before the shard lock
+// when it is written concurrently.
+const maxUpdateAttempts = 3
+
+// update reads the live value of the key and writes the record fn returns for
+// it, no other write of the key comes in between. fn returns false to write
+// nothing, update returns whether the record was written.
+func (s *Storage) update(key string, fn func(e decodedEntry, found bool) (walRecord, bool, error)) (bool, error) {
+	err := s.stall.admit()
+	if err != nil {
+		return false, err
+	}
+
+	idx, err := s.shardIndex(key)
+	if err != nil {
+		return false, err
+	}
+	shard := s.shards[idx]
+
+	e, found, err := s.lookupLocked(shard, key, idx)
+	if err != nil {
+		return false, err
+	}
+
+	rec, ok, err := fn(e, found)
+	if err == nil && ok {
+		rec.key = key
+		err = checkEntrySize(rec.key, rec.value)
+	}
+
+	if err != nil || !ok {
+		shard.mu.Unlock()
+		return false, err
+	}
+
+	size, err := s.commit(shard, &rec)
+	shard.mu.Unlock()
+
+	s.commits.finish(rec.seq)
+	if err != nil {
+		return false, err
+	}
+
+	atomic.AddInt64(&s.shardsSize, size)
+
+	return true, s.maybeScheduleFlush()
+}
+
+// lookupLocked returns the live value of the key with the shard locked, the
+// lock is released on error. The key is read before the lock is taken, under
+// it only the sequence numbers are checked, so a slow read does not stop the
+// writers of the shard. A key written meanwhile is read again, and under the
+// lock once it keeps changing.
+func (s *Storage) lookupLocked(shard *Shard, key string, idx uint32) (decodedEntry, bool, error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		seq := s.commits.visible()
+		e, found, err := s.lookup(key, seq)
+		if err != nil {
+			return decodedEntry{}, false, err
+		}
+
+		shard.mu.Lock()
+
+		changed, err := s.writtenSince(key, idx, seq, found)
+		if err != nil {
+			shard.mu.Unlock()
+			return decodedEntry{}, false, err
+		}
+
+		if !changed {
+			return e, found, nil
+		}
+
+		shard.mu.Unlock()
+	}
+
+	// Writers of the shard are excluded, so every write of the key is in the
+	// memtable and reading past the visible sequence number sees the newest.
+	shard.mu.Lock()
+
+	e, found, err := s.lookup(key, math.MaxUint64)
+	if err != nil {
+		shard.mu.Unlock()
+	}
+
+	return e, found, err
+}
+
+// writtenSince tells whether the key has a version or a range tombstone newer
+// than seq. The caller holds the shard lock, so no write of the key is in
+// flight. A key found at seq without any version left was dropped by a
+// compaction in between.
+func (s *Storage) writtenSince(key string, idx uint32, seq uint64, found bool) (bool, error) {
+	e, ok := s.getFromMemtables(key, idx, math.MaxUint64)
+
+	s.tablesMutex.RLock()
+	defer s.tablesMutex.RUnlock()
+
+	if !ok {
+		var err error
+		e, ok, err = s.getFromTables(key, math.MaxUint64)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if ok && e.seq > seq || found && !ok {
+		return true, nil
+	}
+
+	if !s.hasRangeTombstones() {
+		return false, nil
+	}
+
+	for _, t := range s.coveringRangeTombstones(key, math.MaxUint64) {
+		if t.seq > seq {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Add stores the value only when the key has no live value.
+func (s *Storage) Add(key string, value []byte, flags uint32, expiresAt int64) (bool, error) {
+	return s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		return walRecord{kind: walRecordSet, value: value, flags: flags, expiresAt: expiresAt}, !found, nil
+	})
+}
+
+// Replace stores the value only when the key has a live value.
+func (s *Storage) Replace(key string, value []byte, flags uint32, expiresAt int64) (bool, error) {
+	return s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		return walRecord{kind: walRecordSet, value: value, flags: flags, expiresAt: expiresAt}, found, nil
+	})
+}
+
+// Append adds data after the live value of the key, its flags and expiration
+// are kept. A missing key is not created.
+func (s *Storage) Append(key string, data []byte) (bool, error) {
+	return s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		value := make([]byte, 0, len(e.value)+len(data))
+		value = append(value, e.value...)
+		value = append(value, data...)
+
+		return walRecord{kind: walRecordSet, value: value, flags: e.flags, expiresAt: e.expiresAt}, found, nil
+	})
+}
+
+// Prepend adds data before the live value of the key like Append.
+func (s *Storage) Prepend(key string, data []byte) (bool, error) {
+	return s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		value := make([]byte, 0, len(e.value)+len(data))
+		value = append(value, data...)
+		value = append(value, e.value...)
+
+		return walRecord{kind: walRecordSet, value: value, flags: e.flags, expiresAt: e.expiresAt}, found, nil
+	})
+}
+
+// Increment adds delta to the value of the key, a decimal unsigned 64 bit
+// integer, and returns the result. The sum wraps around.
+func (s *Storage) Increment(key string, delta uint64) (uint64, bool, error) {
+	return s.addDelta(key, func(v uint64) uint64 {
+		return v + delta
+	})
+}
+
+// Decrement subtracts delta from the value of the key like Increment, the
+// result does not go below zero.
+func (s *Storage) Decrement(key string, delta uint64) (uint64, bool, error) {
+	return s.addDelta(key, func(v uint64) uint64 {
+		if delta > v {
+			return 0
+		}
+
+		return v - delta
+	})
+}
+
+func (s *Storage) addDelta(key string, fn func(v uint64) uint64) (uint64, bool, error) {
+	var result uint64
+	stored, err := s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		if !found {
+			return walRecord{}, false, nil
+		}
+
+		v, err := strconv.ParseUint(string(bytes.TrimRight(e.value, " ")), 10, 64)
+		if err != nil {
+			return walRecord{}, false, ErrNotNumeric
+		}
+		result = fn(v)
+
+		value := strconv.AppendUint(nil, result, 10)
+
+		return walRecord{kind: walRecordSet, value: value, flags: e.flags, expiresAt: e.expiresAt}, true, nil
+	})
+
+	return result, stored, err
+}
+
+// Touch sets a new expiration for the live value of the key, the value is
+// written again with it like GetAndTouch does.
+func (s *Storage) Touch(key string, expiresAt int64) (bool, error) {
+	_, _, found, err := s.GetAndTouch(key, expiresAt)
+
+	return found, err
+}
+
+// GetAndTouch returns the live value of the key and sets its new expiration
+// like Touch. The expiration is stored with the value, so the whole value is
+// logged and inserted again and costs as much WAL, memtable and compaction work
+// as setting it.
+func (s *Storage) GetAndTouch(key string, expiresAt int64) ([]byte, uint32, bool, error) {
+	var value []byte
+	var flags uint32
+	found, err := s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		value, flags = e.value, e.flags
+
+		return walRecord{kind: walRecordSet, value: e.value, flags: e.flags, expiresAt: expiresAt}, found, nil
+	})
+	if err != nil || !found {
+		return nil, 0, false, err
+	}
+
+	return value, flags, true, nil
+}
+
+// DeleteExisting deletes the key and tells whether it had a live value.
+func (s *Storage) DeleteExisting(key string) (bool, error) {
+	return s.update(key, func(e decodedEntry, found bool) (walRecord, bool, error) {
+		return walRecord{kind: walRecordDelete}, found, nil
+	})
+}
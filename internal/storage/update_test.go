@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestIncrementIsAtomic(t *testing.T) {
+	s := newTestStorage(t, Options{MaxMemSize: 4 << 10})
+
+	err := s.Set("n", []byte("0"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	const workers, increments = 8, 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < increments; i++ {
+				_, found, err := s.Increment("n", 1)
+				if err != nil || !found {
+					t.Errorf("Increment = %v, %v", found, err)
+					return
+				}
+
+				// Other keys of the store make the memtable rotate.
+				err = s.Set("filler", make([]byte, 256), 0)
+				if err != nil {
+					t.Errorf("Set: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := mustGet(t, s, "n"); value != "1600" {
+		t.Fatalf("got %q, want %q", value, "1600")
+	}
+}
+
+func TestUpdateSeesNewerRangeTombstone(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	err := s.Set("k", []byte("v"), 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	seq := s.commits.visible()
+
+	idx, err := s.shardIndex("k")
+	if err != nil {
+		t.Fatalf("shardIndex: %v", err)
+	}
+
+	changed, err := s.writtenSince("k", idx, seq, true)
+	if err != nil || changed {
+		t.Fatalf("writtenSince before the deletion = %v, %v", changed, err)
+	}
+
+	err = s.DeleteRange("a", "z")
+	if err != nil {
+		t.Fatalf("DeleteRange: %v", err)
+	}
+
+	changed, err = s.writtenSince("k", idx, seq, true)
+	if err != nil || !changed {
+		t.Fatalf("writtenSince after the deletion = %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+func (s *Storage) recover() error {
+	st, _, found, err := readManifest(s.dataDir)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		st, err = s.migrateLegacyTables()
+		if err != nil {
+			return err
+		}
+	}
+
+	for level, tables := range st.levels {
+		for _, meta := range tables {
+			table, err := s.openTable(meta)
+			if err != nil {
+				return err
+			}
+
+			for len(s.levels) <= level {
+				s.levels = append(s.levels, nil)
+			}
+			s.levels[level] = append(s.levels[level], table)
+		}
+	}
+
+	for level := range s.levels {
+		sortLevel(level, s.levels[level])
+	}
+	s.countRangeTombstoneTables()
+
+	s.nextFileNumber = max(st.nextFileNumber, 1)
+	s.lastSequence = st.lastSequence
+	s.walSegment = st.walSegment
+
+	manifestNum := s.newFileNumber()
+	s.manifest, err = createManifest(s.dataDir, manifestNum, s.snapshot())
+	if err != nil {
+		return err
+	}
+
+	return s.removeObsoleteFiles()
+}
+
+func (s *Storage) openTable(meta tableMeta) (*SSTable, error) {
+	table, err := OpenSSTable(tableFileName(s.dataDir, meta.fileNum), s.tableOptions)
+	if err != nil {
+		return nil, fmt.Errorf("table %d: %w", meta.fileNum, err)
+	}
+
+	table.fileNum = meta.fileNum
+	table.smallestSeq = meta.smallestSeq
+	table.largestSeq = meta.largestSeq
+
+	return table, nil
+}
+
+func (s *Storage) newFileNumber() uint64 {
+	return atomic.AddUint64(&s.nextFileNumber, 1) - 1
+}
+
+// snapshot describes the whole live state as a single edit, it is the first
+// record of every new manifest.
+func (s *Storage) snapshot() *versionEdit {
+	e := &versionEdit{formatVersion: manifestFormatVersion}
+	e.setWALSegment(s.walSegment)
+	e.setNextFileNumber(atomic.LoadUint64(&s.nextFileNumber))
+	e.setLastSequence(atomic.LoadUint64(&s.lastSequence))
+
+	s.tablesMutex.RLock()
+	for level, tables := range s.levels {
+		for _, t := range tables {
+			e.addTable(level, t.tableMeta)
+		}
+	}
+	s.tablesMutex.RUnlock()
+
+	return e
+}
+
+// logAndApply durably records the edit in the manifest and only then installs
+// it into the live table set. Tables added by the edit must be passed opened.
+func (s *Storage) logAndApply(edit *versionEdit, added []*SSTable) error {
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
+
+	edit.setNextFileNumber(atomic.LoadUint64(&s.nextFileNumber))
+	edit.setLastSequence(atomic.LoadUint64(&s.lastSequence))
+
+	err := s.manifest.append(edit)
+	if err != nil {
+		return err
+	}
+
+	if edit.hasWALSegment {
+		s.walSegment = edit.walSegment
+	}
+
+	byFileNum := make(map[uint64]*SSTable, len(added))
+	for _, t := range added {
+		byFileNum[t.fileNum] = t
+	}
+
+	s.tablesMutex.Lock()
+	changed := make(map[int]bool)
+
+	for _, d := range edit.deletedTables {
+		if d.level >= len(s.levels) {
+			continue
+		}
+
+		tables := make([]*SSTable, 0, len(s.levels[d.level]))
+		for _, t := range s.levels[d.level] {
+			if t.fileNum != d.fileNum {
+				tables = append(tables, t)
+			}
+		}
+		s.levels[d.level] = tables
+	}
+
+	for _, n := range edit.newTables {
+		table, ok := byFileNum[n.meta.fileNum]
+		if !ok {
+			s.tablesMutex.Unlock()
+			return fmt.Errorf("table %d is added to the manifest but not opened", n.meta.fileNum)
+		}
+
+		for len(s.levels) <= n.level {
+			s.levels = append(s.levels, nil)
+		}
+
+		tables := make([]*SSTable, 0, len(s.levels[n.level])+1)
+		tables = append(tables, s.levels[n.level]...)
+		s.levels[n.level] = append(tables, table)
+		changed[n.level] = true
+	}
+
+	for level := range changed {
+		sortLevel(level, s.levels[level])
+	}
+	s.countRangeTombstoneTables()
+	s.tablesMutex.Unlock()
+
+	if s.manifest.size >= maxManifestSize {
+		err = s.rotateManifest()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) rotateManifest() error {
+	old := s.manifest
+
+	m, err := createManifest(s.dataDir, s.newFileNumber(), s.snapshot())
+	if err != nil {
+		return err
+	}
+	s.manifest = m
+
+	err = old.close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Join(s.dataDir, manifestFileName(old.fileNum)))
+}
+
+// removeObsoleteFiles deletes everything the manifest does not reference:
+// tables left by an interrupted flush or compaction, migrated legacy tables,
+// old manifests and wal segments that are already persisted in tables.
+func (s *Storage) removeObsoleteFiles() error {
+	live := make(map[uint64]bool)
+
+	s.tablesMutex.RLock()
+	for _, tables := range s.levels {
+		for _, t := range tables {
+			live[t.fileNum] = true
+		}
+	}
+	s.tablesMutex.RUnlock()
+
+	files, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		name := f.Name()
+		obsolete := false
+
+		if strings.HasSuffix(name, tableSuffix) {
+			fileNum, ok := parseFileNumber(name, "", tableSuffix)
+			obsolete = !ok || !live[fileNum]
+		} else if fileNum, ok := parseFileNumber(name, manifestPrefix, ""); ok {
+			obsolete = fileNum != s.manifest.fileNum
+		} else if segmentID, ok := parseFileNumber(name, "", walSegmentSuffix); ok {
+			obsolete = segmentID < s.walSegment
+		} else if strings.HasSuffix(name, ".tmp") {
+			obsolete = true
+		}
+
+		if !obsolete {
+			continue
+		}
+
+		log.Printf("Removing obsolete file %s", name)
+
+		err = os.Remove(filepath.Join(s.dataDir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortLevel keeps L0 ordered from the oldest to the newest table and deeper
+// levels ordered by key range.
+func sortLevel(level int, tables []*SSTable) {
+	if level == 0 {
+		sort.Slice(tables, func(i, j int) bool {
+			return tables[i].largestSeq < tables[j].largestSeq
+		})
+
+		return
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].minKey < tables[j].minKey
+	})
+}
@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type WALSyncPolicy int
+
+const (
+	WALSyncAlways WALSyncPolicy = iota
+	WALSyncInterval
+	WALSyncNone
+)
+
+// Record kinds of single keys match the entry kinds, records go into the
+// memtable as they are.
+const (
+	walRecordSet    = entryKindPut
+	walRecordDelete = entryKindDelete
+	walRecordMerge  = entryKindMerge
+	// walRecordBatch holds several records that are replayed all or nothing,
+	// they take consecutive sequence numbers starting from the batch one.
+	walRecordBatch byte = 4
+	// walRecordRangeDelete holds a range tombstone, the key is the start of
+	// the range and the value its end.
+	walRecordRangeDelete byte = 5
+	// walRecordSequenced marks records that carry their sequence number, records
+	// written before sequence numbers existed get one assigned during replay.
+	walRecordSequenced byte = 0x80
+	// walRecordExpires marks records with an expiration time after the flags.
+	walRecordExpires byte = 0x40
+)
+
+const (
+	walSegmentSuffix = ".wal"
+	walBufferSize    = 64 * 1024
+)
+
+type walRecord struct {
+	kind      byte
+	key       string
+	value     []byte
+	flags     uint32
+	seq       uint64
+	expiresAt int64
+}
+
+type WAL struct {
+	mu           sync.Mutex
+	dir          string
+	f            *os.File
+	writer       *bufio.Writer
+	buf          []byte
+	segmentID    uint64
+	syncPolicy   WALSyncPolicy
+	syncInterval time.Duration
+	dirty        bool
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func OpenWAL(dir string, syncPolicy WALSyncPolicy, syncInterval time.Duration) (*WAL, error) {
+	if syncPolicy == WALSyncInterval && syncInterval <= 0 {
+		return nil, fmt.Errorf("wal: sync interval must be positive")
+	}
+
+	segments, err := listWALSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segmentID uint64 = 1
+	if len(segments) > 0 {
+		segmentID = segments[len(segments)-1] + 1
+	}
+
+	w := &WAL{
+		dir:          dir,
+		syncPolicy:   syncPolicy,
+		syncInterval: syncInterval,
+		stop:         make(chan struct{}),
+	}
+
+	err = w.openSegment(segmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if syncPolicy == WALSyncInterval {
+		w.wg.Go(w.syncLoop)
+	}
+
+	return w, nil
+}
+
+func (w *WAL) Append(rec walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = encodeWALRecord(w.buf[:0], rec)
+
+	return w.write()
+}
+
+// AppendBatch logs the records as a single unit, their sequence numbers must
+// be consecutive.
+func (w *WAL) AppendBatch(recs []walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = encodeWALBatch(w.buf[:0], recs)
+
+	return w.write()
+}
+
+func (w *WAL) write() error {
+	_, err := w.writer.Write(w.buf)
+	if err != nil {
+		return err
+	}
+
+	if w.syncPolicy == WALSyncAlways {
+		return w.sync()
+	}
+
+	w.dirty = true
+
+	return w.writer.Flush()
+}
+
+func (w *WAL) Rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.sync()
+	if err != nil {
+		return 0, err
+	}
+
+	err = w.f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = w.openSegment(w.segmentID + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.segmentID, nil
+}
+
+func (w *WAL) RemoveBefore(segmentID uint64) error {
+	segments, err := listWALSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range segments {
+		if id >= segmentID {
+			break
+		}
+
+		err = os.Remove(walSegmentPath(w.dir, id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *WAL) Close() error {
+	close(w.stop)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.sync()
+	if err != nil {
+		return err
+	}
+
+	return w.f.Close()
+}
+
+func (w *WAL) openSegment(segmentID uint64) error {
+	f, err := os.OpenFile(walSegmentPath(w.dir, segmentID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.f = f
+	w.writer = bufio.NewWriterSize(f, walBufferSize)
+	w.segmentID = segmentID
+	w.dirty = false
+
+	return nil
+}
+
+func (w *WAL) sync() error {
+	err := w.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	if w.syncPolicy == WALSyncNone {
+		return nil
+	}
+
+	w.dirty = false
+
+	return w.f.Sync()
+}
+
+func (w *WAL) syncLoop() {
+	ticker := time.NewTicker(w.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty {
+				err := w.sync()
+				if err != nil {
+					log.Printf("Error during wal sync %v", err)
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func ReplayWAL(dir string, fromSegmentID uint64, fn func(rec walRecord) error) error {
+	segments, err := listWALSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range segments {
+		if id < fromSegmentID {
+			continue
+		}
+
+		path := walSegmentPath(dir, id)
+		var size int64
+		size, err = replayWALSegment(path, fn)
+
+		// The tail is cut off, otherwise the segment would no longer be the
+		// last one after a restart and its torn record would stop replay.
+		if errors.Is(err, errTornRecord) && i == len(segments)-1 {
+			log.Printf("Torn record at the tail of wal segment %d, truncating it", id)
+			err = os.Truncate(path, size)
+		}
+
+		if err != nil {
+			return fmt.Errorf("wal segment %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+func replayWALSegment(path string, fn func(rec walRecord) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return readRecords(f, func(payload []byte) error {
+		// The checksum matched, so only a crash could have left such a record.
+		recs, err := decodeWALRecords(payload)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errTornRecord, err)
+		}
+
+		for _, rec := range recs {
+			err = fn(rec)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func encodeWALRecord(buf []byte, rec walRecord) []byte {
+	buf = appendRecordHeader(buf)
+	buf = append(buf, walKind(rec)|walRecordSequenced)
+	buf = binary.BigEndian.AppendUint64(buf, rec.seq)
+	buf = appendWALBody(buf, rec)
+
+	sealRecord(buf)
+
+	return buf
+}
+
+func encodeWALBatch(buf []byte, recs []walRecord) []byte {
+	buf = appendRecordHeader(buf)
+	buf = append(buf, walRecordBatch|walRecordSequenced)
+	buf = binary.BigEndian.AppendUint64(buf, recs[0].seq)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(recs)))
+
+	for _, rec := range recs {
+		buf = append(buf, walKind(rec))
+		buf = appendWALBody(buf, rec)
+	}
+
+	sealRecord(buf)
+
+	return buf
+}
+
+func walKind(rec walRecord) byte {
+	if rec.expiresAt != 0 {
+		return rec.kind | walRecordExpires
+	}
+
+	return rec.kind
+}
+
+func appendWALBody(buf []byte, rec walRecord) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, rec.flags)
+	if rec.expiresAt != 0 {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(rec.expiresAt))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(rec.key)))
+	buf = append(buf, rec.key...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(rec.value)))
+	buf = append(buf, rec.value...)
+
+	return buf
+}
+
+func decodeWALRecords(payload []byte) ([]walRecord, error) {
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("wal: record is too short")
+	}
+
+	kind := payload[0] &^ walRecordSequenced
+	pos := 1
+
+	var seq uint64
+	if payload[0]&walRecordSequenced != 0 {
+		if len(payload) < pos+8 {
+			return nil, fmt.Errorf("wal: record is too short")
+		}
+		seq = binary.BigEndian.Uint64(payload[pos : pos+8])
+		pos += 8
+	}
+
+	if kind&^walRecordExpires != walRecordBatch {
+		rec := walRecord{kind: kind &^ walRecordExpires, seq: seq}
+
+		pos, err := decodeWALBody(payload, pos, kind&walRecordExpires != 0, &rec)
+		if err != nil {
+			return nil, err
+		}
+
+		if pos != len(payload) {
+			return nil, fmt.Errorf("wal: record value is out of bounds")
+		}
+
+		return []walRecord{rec}, nil
+	}
+
+	if len(payload) < pos+4 {
+		return nil, fmt.Errorf("wal: batch is too short")
+	}
+	count := int(binary.BigEndian.Uint32(payload[pos : pos+4]))
+	pos += 4
+
+	recs := make([]walRecord, 0, min(count, len(payload)))
+	for i := 0; i < count; i++ {
+		if len(payload) < pos+1 {
+			return nil, fmt.Errorf("wal: batch is too short")
+		}
+
+		kind := payload[pos]
+		rec := walRecord{kind: kind &^ walRecordExpires, seq: seq + uint64(i)}
+
+		var err error
+		pos, err = decodeWALBody(payload, pos+1, kind&walRecordExpires != 0, &rec)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, rec)
+	}
+
+	if pos != len(payload) {
+		return nil, fmt.Errorf("wal: batch has trailing bytes")
+	}
+
+	return recs, nil
+}
+
+func decodeWALBody(payload []byte, pos int, expires bool, rec *walRecord) (int, error) {
+	if len(payload) < pos+4 {
+		return 0, fmt.Errorf("wal: record is too short")
+	}
+
+	rec.flags = binary.BigEndian.Uint32(payload[pos : pos+4])
+	pos += 4
+
+	if expires {
+		if len(payload) < pos+8 {
+			return 0, fmt.Errorf("wal: record is too short")
+		}
+		rec.expiresAt = int64(binary.BigEndian.Uint64(payload[pos : pos+8]))
+		pos += 8
+	}
+
+	if len(payload) < pos+4 {
+		return 0, fmt.Errorf("wal: record is too short")
+	}
+	kLen := int(binary.BigEndian.Uint32(payload[pos : pos+4]))
+	pos += 4
+
+	if len(payload) < pos+kLen+4 {
+		return 0, fmt.Errorf("wal: record key is out of bounds")
+	}
+	rec.key = string(payload[pos : pos+kLen])
+	pos += kLen
+
+	vLen := int(binary.BigEndian.Uint32(payload[pos : pos+4]))
+	pos += 4
+
+	if len(payload) < pos+vLen {
+		return 0, fmt.Errorf("wal: record value is out of bounds")
+	}
+
+	if rec.kind == walRecordSet || rec.kind == walRecordMerge || rec.kind == walRecordRangeDelete {
+		rec.value = make([]byte, vLen)
+		copy(rec.value, payload[pos:pos+vLen])
+	}
+	pos += vLen
+
+	return pos, nil
+}
+
+func listWALSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), walSegmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), walSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, id)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+
+	return segments, nil
+}
+
+func walSegmentPath(dir string, segmentID uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%d%s", segmentID, walSegmentSuffix))
+}
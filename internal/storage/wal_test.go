@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWALReplayRestoresWrites(t *testing.T) {
+	opts := Options{DataDir: t.TempDir(), MergeOperator: NewAppendOperator([]byte(","))}
+	s := newTestStorage(t, opts)
+
+	batch := NewWriteBatch()
+	batch.Put("b1", []byte("v"), 0)
+	batch.Put("b2", []byte("v"), 0)
+	batch.Delete("a")
+
+	for _, write := range []func() error{
+		func() error { return s.Set("a", []byte("1"), 7) },
+		func() error { return s.Set("d", []byte("1"), 0) },
+		func() error { return s.SetWithExpiry("e", []byte("1"), 0, 1) },
+		func() error { return s.Merge("m", []byte("x")) },
+		func() error { return s.Merge("m", []byte("y")) },
+		func() error { return s.Write(batch) },
+		func() error { return s.DeleteRange("c", "e") },
+	} {
+		err := write()
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	lastSequence := s.lastSequence
+
+	crashTestStorage(t, s)
+	s = newTestStorage(t, opts)
+
+	if s.lastSequence != lastSequence {
+		t.Fatalf("recovered sequence %d, want %d", s.lastSequence, lastSequence)
+	}
+
+	for key, want := range map[string]string{"m": "x,y", "b1": "v", "b2": "v"} {
+		if value, found := mustGet(t, s, key); !found || value != want {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, value, found, want)
+		}
+	}
+
+	for _, key := range []string{"a", "d", "e"} {
+		if value, found := mustGet(t, s, key); found {
+			t.Fatalf("Get(%q) = %q, want no value", key, value)
+		}
+	}
+}
+
+func TestWALReplayTruncatesTornTail(t *testing.T) {
+	for name, tail := range map[string][]byte{
+		// The header of a record whose payload never made it to disk.
+		"missing payload": {0, 0, 0, 0, 0, 0, 0, 100, 1, 2, 3},
+		// Space the file system allocated but never wrote, an empty payload
+		// matches a zero checksum.
+		"zero filled": make([]byte, 4096),
+		// A length that would need gigabytes to read.
+		"garbage length": {1, 2, 3, 4, 0xff, 0xff, 0xff, 0xff, 5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{DataDir: t.TempDir()}
+			s := newTestStorage(t, opts)
+
+			err := s.Set("a", []byte("1"), 0)
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			crashTestStorage(t, s)
+
+			segments, err := listWALSegments(opts.DataDir)
+			if err != nil || len(segments) == 0 {
+				t.Fatalf("listWALSegments = %v, %v", segments, err)
+			}
+			path := walSegmentPath(opts.DataDir, segments[len(segments)-1])
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatalf("OpenFile: %v", err)
+			}
+			_, err = f.Write(tail)
+			f.Close()
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+
+			s = newTestStorage(t, opts)
+
+			truncated, err := os.Stat(path)
+			if err != nil || truncated.Size() != info.Size() {
+				t.Fatalf("segment is %v bytes after replay, want %d: %v", truncated.Size(), info.Size(), err)
+			}
+
+			err = s.Set("b", []byte("2"), 0)
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+
+			// The torn segment is no longer the last one on the second recovery.
+			crashTestStorage(t, s)
+			s = newTestStorage(t, opts)
+
+			for key, want := range map[string]string{"a": "1", "b": "2"} {
+				if value, found := mustGet(t, s, key); !found || value != want {
+					t.Fatalf("Get(%q) = %q, %v, want %q", key, value, found, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenWALRejectsZeroSyncInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := OpenWAL(dir, WALSyncInterval, 0)
+	if err == nil {
+		t.Fatal("OpenWAL accepted a zero sync interval")
+	}
+
+	segments, err := listWALSegments(dir)
+	if err != nil || len(segments) != 0 {
+		t.Fatalf("segments %v were created: %v", segments, err)
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// WriteBatch collects writes that Storage.Write logs as a single unit and makes
+// visible atomically, later operations on a key see the earlier ones.
+type WriteBatch struct {
+	ops []walRecord
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (b *WriteBatch) Put(key string, value []byte, flags uint32) {
+	b.ops = append(b.ops, walRecord{kind: walRecordSet, key: key, value: value, flags: flags})
+}
+
+func (b *WriteBatch) Delete(key string) {
+	b.ops = append(b.ops, walRecord{kind: walRecordDelete, key: key})
+}
+
+func (b *WriteBatch) Merge(key string, operand []byte) {
+	b.ops = append(b.ops, walRecord{kind: walRecordMerge, key: key, value: operand})
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+func (s *Storage) Write(batch *WriteBatch) error {
+	if len(batch.ops) == 0 {
+		return nil
+	}
+
+	err := s.stall.admit()
+	if err != nil {
+		return err
+	}
+
+	shardIdxs := make([]uint32, len(batch.ops))
+	locked := make(map[uint32]bool)
+	for i, op := range batch.ops {
+		if op.kind == walRecordMerge && s.mergeOperator == nil {
+			return ErrNoMergeOperator
+		}
+
+		err := checkEntrySize(op.key, op.value)
+		if err != nil {
+			return err
+		}
+
+		idx, err := s.shardIndex(op.key)
+		if err != nil {
+			return err
+		}
+
+		shardIdxs[i] = idx
+		locked[idx] = true
+	}
+
+	// Shards are locked in index order so concurrent batches cannot deadlock.
+	order := make([]uint32, 0, len(locked))
+	for idx := range locked {
+		order = append(order, idx)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return order[i] < order[j]
+	})
+
+	for _, idx := range order {
+		s.shards[idx].mu.Lock()
+	}
+
+	unlock := func() {
+		for _, idx := range order {
+			s.shards[idx].mu.Unlock()
+		}
+	}
+
+	recs := make([]walRecord, len(batch.ops))
+	copy(recs, batch.ops)
+
+	last := atomic.AddUint64(&s.lastSequence, uint64(len(recs)))
+	first := last - uint64(len(recs)) + 1
+	for i := range recs {
+		recs[i].seq = first + uint64(i)
+	}
+
+	err = s.wal.AppendBatch(recs)
+	if err != nil {
+		unlock()
+		s.commits.finishRange(first, last)
+		return err
+	}
+
+	var sizeDelta int64
+	for i, rec := range recs {
+		sizeDelta += s.insert(s.shards[shardIdxs[i]], rec)
+	}
+	unlock()
+
+	s.commits.finishRange(first, last)
+
+	atomic.AddInt64(&s.shardsSize, sizeDelta)
+
+	return s.maybeScheduleFlush()
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteBatchIsAtomic(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	// The keys spread over the shards, the padding rotates memtables while
+	// the readers run.
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%02d", i)
+	}
+	padding := strings.Repeat("x", 512)
+
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+
+	wg.Go(func() {
+		defer done.Store(true)
+
+		batch := NewWriteBatch()
+		for round := 1; round <= 1000; round++ {
+			batch.Reset()
+			for _, key := range keys {
+				if round%5 == 0 {
+					batch.Delete(key)
+					continue
+				}
+				batch.Put(key, []byte(fmt.Sprintf("%d-%s", round, padding)), 0)
+			}
+
+			err := s.Write(batch)
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+
+	// same reports whether every key has the same value or every key is gone.
+	same := func(get func(key string) (string, bool, error)) error {
+		first, firstFound, err := get(keys[0])
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys[1:] {
+			value, found, err := get(key)
+			if err != nil {
+				return err
+			}
+
+			if found != firstFound || value != first {
+				return fmt.Errorf("%s=%.8q, %v but %s=%.8q, %v", keys[0], first, firstFound, key, value, found)
+			}
+		}
+
+		return nil
+	}
+
+	wg.Go(func() {
+		for !done.Load() {
+			snap := s.NewSnapshot()
+			err := same(func(key string) (string, bool, error) {
+				value, _, found, err := snap.Get(key)
+				return string(value), found, err
+			})
+			snap.Release()
+
+			if err != nil {
+				errs <- fmt.Errorf("snapshot: %w", err)
+				return
+			}
+		}
+	})
+
+	wg.Go(func() {
+		for !done.Load() {
+			it := s.NewIterator("", "")
+			values := make(map[string]string)
+			for it.Next() {
+				values[it.Key()] = string(it.Value())
+			}
+			err := it.Err()
+			it.Close()
+
+			if err == nil {
+				err = same(func(key string) (string, bool, error) {
+					value, found := values[key]
+					return value, found, nil
+				})
+			}
+
+			if err != nil {
+				errs <- fmt.Errorf("iterator: %w", err)
+				return
+			}
+		}
+	})
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWriteBatchRejectedAsWhole(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	for _, tc := range []struct {
+		name string
+		fill func(b *WriteBatch)
+		want error
+	}{
+		{"merge without an operator", func(b *WriteBatch) { b.Merge("m", []byte("x")) }, ErrNoMergeOperator},
+		{"key too large", func(b *WriteBatch) { b.Put(strings.Repeat("k", MaxKeySize+1), nil, 0) }, ErrKeyTooLarge},
+	} {
+		lastSequence := s.lastSequence
+
+		// The invalid operation comes last, after writes that are valid.
+		batch := NewWriteBatch()
+		batch.Put("a", []byte("1"), 0)
+		batch.Delete("b")
+		batch.Put("c", []byte("3"), 0)
+		tc.fill(batch)
+
+		err := s.Write(batch)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s: Write returned %v, want %v", tc.name, err, tc.want)
+		}
+
+		if s.lastSequence != lastSequence {
+			t.Fatalf("%s: sequence moved from %d to %d", tc.name, lastSequence, s.lastSequence)
+		}
+
+		for _, key := range []string{"a", "c", "m"} {
+			if value, found := mustGet(t, s, key); found {
+				t.Fatalf("%s: Get(%q) = %q, want no value", tc.name, key, value)
+			}
+		}
+	}
+
+	// Nothing of the rejected batches was logged either.
+	crashTestStorage(t, s)
+	s = newTestStorage(t, Options{DataDir: s.dataDir})
+
+	if s.lastSequence != 0 {
+		t.Fatalf("recovered sequence %d, want 0", s.lastSequence)
+	}
+}
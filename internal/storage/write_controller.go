@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultL0SlowdownWritesTrigger    = 20
+	DefaultL0StopWritesTrigger        = 36
+	DefaultSoftPendingCompactionBytes = 256 * 1024 * 1024
+	DefaultHardPendingCompactionBytes = 1024 * 1024 * 1024
+	DefaultWriteSlowdownDelay         = time.Millisecond
+	DefaultWriteStallTimeout          = time.Second
+)
+
+// ErrWriteStall is returned when writes stay stopped for longer than the
+// stall timeout, the write can be retried once flushes and compactions catch
+// up.
+var ErrWriteStall = errors.New("storage: writes are stalled")
+
+type WriteStallState int
+
+const (
+	WriteStallNone WriteStallState = iota
+	WriteStallDelayed
+	WriteStallStopped
+)
+
+func (s WriteStallState) String() string {
+	switch s {
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	default:
+		return "none"
+	}
+}
+
+type WriteStallStats struct {
+	State                  WriteStallState
+	Reason                 string
+	ImmutableMemtables     int
+	L0Tables               int
+	PendingCompactionBytes int64
+	DelayedWrites          int64
+	RejectedWrites         int64
+}
+
+// writeController delays writes when flushes or compactions fall behind and
+// stops them when they fall too far behind.
+type writeController struct {
+	updateMutex sync.Mutex
+	mu          sync.Mutex
+	stats       WriteStallStats
+	changed     chan struct{}
+	// err fails every write once the background work cannot go on.
+	err error
+
+	maxImmutables    int
+	countL0          bool
+	l0Slowdown       int
+	l0Stop           int
+	softPendingBytes int64
+	hardPendingBytes int64
+	slowdownDelay    time.Duration
+	stallTimeout     time.Duration
+
+	delayedWrites  int64
+	rejectedWrites int64
+}
+
+func newWriteController(opts Options, maxImmutables int) *writeController {
+	c := &writeController{
+		changed:          make(chan struct{}),
+		maxImmutables:    maxImmutables,
+		countL0:          opts.CompactionStrategy == CompactionLeveled,
+		l0Slowdown:       opts.L0SlowdownWritesTrigger,
+		l0Stop:           opts.L0StopWritesTrigger,
+		softPendingBytes: opts.SoftPendingCompactionBytes,
+		hardPendingBytes: opts.HardPendingCompactionBytes,
+		slowdownDelay:    opts.WriteSlowdownDelay,
+		stallTimeout:     opts.WriteStallTimeout,
+	}
+
+	if c.l0Slowdown <= 0 {
+		c.l0Slowdown = DefaultL0SlowdownWritesTrigger
+	}
+
+	if c.l0Stop <= 0 {
+		c.l0Stop = DefaultL0StopWritesTrigger
+	}
+
+	if c.softPendingBytes <= 0 {
+		c.softPendingBytes = DefaultSoftPendingCompactionBytes
+	}
+
+	if c.hardPendingBytes <= 0 {
+		c.hardPendingBytes = DefaultHardPendingCompactionBytes
+	}
+
+	if c.slowdownDelay <= 0 {
+		c.slowdownDelay = DefaultWriteSlowdownDelay
+	}
+
+	if c.stallTimeout <= 0 {
+		c.stallTimeout = DefaultWriteStallTimeout
+	}
+
+	return c
+}
+
+// update recomputes the stall state and wakes up stopped writers when it
+// changes.
+func (c *writeController) update(immutables int, l0Tables int, pendingBytes int64) {
+	state, reason := WriteStallNone, ""
+
+	switch {
+	case immutables >= c.maxImmutables:
+		state, reason = WriteStallStopped, "immutable memtables"
+	case c.countL0 && l0Tables >= c.l0Stop:
+		state, reason = WriteStallStopped, "l0 tables"
+	case pendingBytes >= c.hardPendingBytes:
+		state, reason = WriteStallStopped, "pending compaction bytes"
+	case c.maxImmutables > 1 && immutables >= c.maxImmutables-1:
+		state, reason = WriteStallDelayed, "immutable memtables"
+	case c.countL0 && l0Tables >= c.l0Slowdown:
+		state, reason = WriteStallDelayed, "l0 tables"
+	case pendingBytes >= c.softPendingBytes:
+		state, reason = WriteStallDelayed, "pending compaction bytes"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.ImmutableMemtables = immutables
+	c.stats.L0Tables = l0Tables
+	c.stats.PendingCompactionBytes = pendingBytes
+
+	if state != c.stats.State {
+		c.stats.State = state
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	c.stats.Reason = reason
+}
+
+// fail rejects every later write with err and wakes up the stopped writers.
+func (c *writeController) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *writeController) state() (WriteStallState, chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats.State, c.changed, c.err
+}
+
+// admit delays the write while writes are slowed down and waits while they are
+// stopped, giving up with ErrWriteStall after the stall timeout.
+func (c *writeController) admit() error {
+	var timer *time.Timer
+
+	for {
+		state, changed, err := c.state()
+		if err != nil {
+			return err
+		}
+
+		switch state {
+		case WriteStallNone:
+			return nil
+		case WriteStallDelayed:
+			atomic.AddInt64(&c.delayedWrites, 1)
+			time.Sleep(c.slowdownDelay)
+			return nil
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(c.stallTimeout)
+			defer timer.Stop()
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			atomic.AddInt64(&c.rejectedWrites, 1)
+			return ErrWriteStall
+		}
+	}
+}
+
+func (c *writeController) statsSnapshot() WriteStallStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	stats.DelayedWrites = atomic.LoadInt64(&c.delayedWrites)
+	stats.RejectedWrites = atomic.LoadInt64(&c.rejectedWrites)
+
+	return stats
+}
+
+// updateWriteStall feeds the current flush and compaction backlog to the write
+// controller, it runs whenever the memtables or the table set change.
+func (s *Storage) updateWriteStall() {
+	s.stall.updateMutex.Lock()
+	defer s.stall.updateMutex.Unlock()
+
+	s.tablesMutex.RLock()
+	l0Tables := len(s.levels[0])
+	pendingBytes := s.compactor.picker.pendingBytes(s.levels)
+	s.tablesMutex.RUnlock()
+
+	s.stall.update(s.immutableCount(), l0Tables, pendingBytes)
+}
+
+func (s *Storage) WriteStallStats() WriteStallStats {
+	return s.stall.statsSnapshot()
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteControllerThresholds(t *testing.T) {
+	c := newWriteController(Options{
+		CompactionStrategy:         CompactionLeveled,
+		L0SlowdownWritesTrigger:    4,
+		L0StopWritesTrigger:        8,
+		SoftPendingCompactionBytes: 100,
+		HardPendingCompactionBytes: 200,
+	}, 3)
+
+	for _, tc := range []struct {
+		immutables   int
+		l0Tables     int
+		pendingBytes int64
+		state        WriteStallState
+		reason       string
+	}{
+		{0, 0, 0, WriteStallNone, ""},
+		{1, 3, 99, WriteStallNone, ""},
+		{2, 0, 0, WriteStallDelayed, "immutable memtables"},
+		{0, 4, 0, WriteStallDelayed, "l0 tables"},
+		{0, 0, 100, WriteStallDelayed, "pending compaction bytes"},
+		{3, 0, 0, WriteStallStopped, "immutable memtables"},
+		{0, 8, 0, WriteStallStopped, "l0 tables"},
+		{0, 0, 200, WriteStallStopped, "pending compaction bytes"},
+		// A stop trigger wins over every slowdown trigger.
+		{2, 4, 200, WriteStallStopped, "pending compaction bytes"},
+		{0, 0, 0, WriteStallNone, ""},
+	} {
+		c.update(tc.immutables, tc.l0Tables, tc.pendingBytes)
+
+		stats := c.statsSnapshot()
+		if stats.State != tc.state || stats.Reason != tc.reason {
+			t.Fatalf("update(%d, %d, %d) = %v %q, want %v %q", tc.immutables, tc.l0Tables, tc.pendingBytes, stats.State, stats.Reason, tc.state, tc.reason)
+		}
+	}
+
+	// Size-tiered compaction has no levels, so L0 tables never stall writes.
+	c = newWriteController(Options{L0StopWritesTrigger: 1}, 3)
+	c.update(0, 10, 0)
+	if state, _, _ := c.state(); state != WriteStallNone {
+		t.Fatalf("size-tiered state with L0 tables is %v", state)
+	}
+}
+
+func TestWriteControllerAdmit(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	const timeout = 50 * time.Millisecond
+
+	c := newWriteController(Options{WriteSlowdownDelay: delay, WriteStallTimeout: timeout}, 3)
+
+	admit := func() (time.Duration, error) {
+		startedAt := time.Now()
+		err := c.admit()
+
+		return time.Since(startedAt), err
+	}
+
+	if took, err := admit(); err != nil || took >= delay {
+		t.Fatalf("admit without a stall took %v: %v", took, err)
+	}
+
+	c.update(2, 0, 0)
+	if took, err := admit(); err != nil || took < delay {
+		t.Fatalf("delayed admit took %v, want at least %v: %v", took, delay, err)
+	}
+
+	c.update(3, 0, 0)
+	if took, err := admit(); !errors.Is(err, ErrWriteStall) || took < timeout {
+		t.Fatalf("stopped admit took %v and returned %v, want %v after %v", took, err, ErrWriteStall, timeout)
+	}
+
+	stats := c.statsSnapshot()
+	if stats.DelayedWrites != 1 || stats.RejectedWrites != 1 {
+		t.Fatalf("counted %d delayed and %d rejected writes, want 1 and 1", stats.DelayedWrites, stats.RejectedWrites)
+	}
+}
+
+func TestWriteControllerReleasesStoppedWrites(t *testing.T) {
+	c := newWriteController(Options{WriteStallTimeout: time.Minute}, 3)
+
+	for _, release := range []struct {
+		name string
+		fn   func()
+		want error
+	}{
+		{"cleared", func() { c.update(0, 0, 0) }, nil},
+		{"failed", func() { c.fail(ErrFlushFailed) }, ErrFlushFailed},
+	} {
+		c.update(3, 0, 0)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- c.admit()
+		}()
+
+		select {
+		case err := <-done:
+			t.Fatalf("%s: admit returned %v while writes are stopped", release.name, err)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		// A change that keeps writes stopped does not let the write through.
+		c.update(0, 0, 1<<40)
+
+		select {
+		case err := <-done:
+			t.Fatalf("%s: admit returned %v while writes are still stopped", release.name, err)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		release.fn()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, release.want) {
+				t.Fatalf("%s: admit returned %v, want %v", release.name, err, release.want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: admit was not woken up", release.name)
+		}
+	}
+}